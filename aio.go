@@ -0,0 +1,535 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <sys/uio.h>
+// #include <rbd/librbd.h>
+//
+// extern void goAioCallback(rbd_completion_t, void*);
+//
+// static inline int _rbd_aio_create_completion(uintptr_t arg, rbd_completion_t *c) {
+// 	return rbd_aio_create_completion((void*)arg, goAioCallback, c);
+// }
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+////
+//   Buffer ownership
+////
+//
+// cgo forbids C code from retaining pointers to Go memory once a call has
+// returned, and librbd holds on to I/O buffers until an asynchronous
+// operation completes. Go buffers are therefore never handed to librbd by the
+// AIO calls. Instead:
+//
+//   - Write data is copied into C memory before the Aio* call returns, so
+//     the caller may reuse its buffer straight away.
+//   - Reads land in C memory and are copied into the caller's buffers on the
+//     librbd callback thread, just before the Completion is marked done.
+//     The caller must not touch those buffers until then, unless it has
+//     abandoned the operation through WaitContext().
+//   - C memory, including iovec arrays and the compare and write mismatch
+//     offset, is freed by the Completion when librbd calls back, or
+//     immediately if submission fails. Callers never need to release it.
+//   - librbd is only ever given an integer ID for the Completion, which is
+//     kept alive by the callback registry until the callback has run.
+//
+// The synchronous data path passes Go buffers directly, since librbd does
+// not keep them beyond the call.
+
+// An asynchronous operation submitted against an image. Data for the
+// operation is staged in C memory owned by the Completion, so the caller's
+// buffer is only touched at submission (writes) or completion (reads)
+type Completion struct {
+	image  *Image
+	op     aioOp
+	offset uint64
+	bufs   [][]byte
+	length int
+	cbuf   unsafe.Pointer
+	iov    *C.struct_iovec
+	id     uintptr
+	done   chan struct{}
+	result int64
+
+	// Guards delivery of read data against the caller abandoning the
+	// operation through WaitContext(). completed is set, under the lock,
+	// as soon as librbd has called back, which may be before done is closed
+	lock      sync.Mutex
+	abandoned bool
+	completed bool
+
+	// Compare and write only
+	cmismatch *C.uint64_t
+	mismatch  uint64
+
+	// Asynchronous open only; librbd fills in the handle on completion
+	chandle *C.rbd_image_t
+}
+
+type aioOp int
+
+const (
+	aioRead aioOp = iota
+	aioWrite
+	aioDiscard
+	aioFlush
+	aioCompareAndWrite
+	aioOpen
+	aioClose
+)
+
+// Describes the operation for error messages
+func (op aioOp) String() string {
+	switch op {
+	case aioRead:
+		return "read from"
+	case aioWrite:
+		return "write to"
+	case aioDiscard:
+		return "discard"
+	case aioFlush:
+		return "flush"
+	case aioCompareAndWrite:
+		return "compare and write"
+	case aioOpen:
+		return "open"
+	case aioClose:
+		return "close"
+	}
+
+	return "access"
+}
+
+// Reports whether the operation applies at an offset within the image
+func (op aioOp) hasOffset() bool {
+	switch op {
+	case aioRead, aioWrite, aioDiscard, aioCompareAndWrite:
+		return true
+	}
+
+	return false
+}
+
+func newCompletion(image *Image, op aioOp, offset uint64) *Completion {
+	return &Completion{
+		image:  image,
+		op:     op,
+		offset: offset,
+		done:   make(chan struct{}),
+	}
+}
+
+// Create the librbd completion. The Completion stays registered until
+// librbd calls back, which keeps it reachable while the operation is in flight
+func (c *Completion) create() (C.rbd_completion_t, error) {
+	var handle C.rbd_completion_t
+
+	c.id = registerCallback(c)
+
+	if result := C._rbd_aio_create_completion(C.uintptr_t(c.id), &handle); result < 0 {
+		c.abort(nil)
+		return nil, fmt.Errorf("Unable to create completion for image '%s'", c.image.name)
+	}
+
+	return handle, nil
+}
+
+// Undo create() after an operation failed to submit
+func (c *Completion) abort(handle C.rbd_completion_t) {
+	if handle != nil {
+		C.rbd_aio_release(handle)
+	}
+
+	unregisterCallback(c.id)
+	c.release()
+}
+
+func (c *Completion) release() {
+	C.free(c.cbuf)
+	c.cbuf = nil
+	C.free(unsafe.Pointer(c.iov))
+	c.iov = nil
+	C.free(unsafe.Pointer(c.cmismatch))
+	c.cmismatch = nil
+	C.free(unsafe.Pointer(c.chandle))
+	c.chandle = nil
+}
+
+// Stage the caller's buffers in a single C allocation, described by an
+// iovec array for the vectored calls
+func (c *Completion) stage(bufs [][]byte, copyIn bool) {
+	c.length = 0
+	for _, buf := range bufs {
+		c.length += len(buf)
+	}
+
+	c.cbuf = C.malloc(C.size_t(c.length))
+	c.iov = (*C.struct_iovec)(C.calloc(C.size_t(len(bufs)), C.sizeof_struct_iovec))
+
+	staged := unsafe.Slice((*byte)(c.cbuf), c.length)
+	iov := unsafe.Slice(c.iov, len(bufs))
+	offset := 0
+
+	for i, buf := range bufs {
+		iov[i].iov_base = unsafe.Add(c.cbuf, offset)
+		iov[i].iov_len = C.size_t(len(buf))
+
+		if copyIn {
+			copy(staged[offset:], buf)
+		}
+
+		offset += len(buf)
+	}
+}
+
+// Called from the librbd callback thread once the operation has finished
+func (c *Completion) complete(result int64) {
+	c.lock.Lock()
+	if c.bufs != nil && result > 0 && !c.abandoned {
+		staged := unsafe.Slice((*byte)(c.cbuf), result)
+
+		for _, buf := range c.bufs {
+			staged = staged[copy(buf, staged):]
+		}
+	}
+	c.completed = true
+	c.lock.Unlock()
+
+	if c.cmismatch != nil {
+		c.mismatch = uint64(*c.cmismatch)
+	}
+
+	if c.chandle != nil && result >= 0 {
+		c.image.handle = *c.chandle
+	}
+
+	// librbd has freed the handle, so a later Close() must not touch it
+	if c.op == aioClose && result >= 0 {
+		c.image.handle = nil
+	}
+
+	c.release()
+	c.result = result
+
+	close(c.done)
+}
+
+//export goAioCallback
+func goAioCallback(handle C.rbd_completion_t, arg unsafe.Pointer) {
+	result := int64(C.rbd_aio_get_return_value(handle))
+	C.rbd_aio_release(handle)
+
+	c, _ := lookupCallback(uintptr(arg)).(*Completion)
+	if c == nil {
+		return
+	}
+
+	unregisterCallback(c.id)
+	c.complete(result)
+}
+
+// Returns a channel which is closed once the operation has completed, for use
+// in select statements
+func (c *Completion) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Completion) IsComplete() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the number of bytes transferred, blocking until the operation has
+// completed
+func (c *Completion) Bytes() int {
+	<-c.done
+
+	if c.result < 0 {
+		return 0
+	}
+
+	return int(c.result)
+}
+
+// Returns the outcome of the operation, blocking until it has completed.
+// Short reads return io.EOF as with Image.Read()
+func (c *Completion) Err() error {
+	<-c.done
+
+	if c.result == -C.EILSEQ && c.op == aioCompareAndWrite {
+		return ErrCompareMismatch
+	}
+
+	if c.result < 0 {
+		if !c.op.hasOffset() {
+			return fmt.Errorf("Unable to %s image '%s'", c.op, c.image.name)
+		}
+
+		return fmt.Errorf("Unable to %s image '%s' at offset %d", c.op, c.image.name, c.offset)
+	}
+
+	if c.bufs != nil && int(c.result) < c.length {
+		return io.EOF
+	}
+
+	return nil
+}
+
+// For a failed compare and write, returns the offset of the first byte that
+// did not match. Blocks until the operation has completed
+func (c *Completion) MismatchOffset() uint64 {
+	<-c.done
+
+	return c.mismatch
+}
+
+// For an asynchronous open, returns the opened image once the operation has
+// completed successfully, blocking until then
+func (c *Completion) Image() (*Image, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.image, nil
+}
+
+// Block until the operation has completed, returning the number of bytes
+// transferred and its outcome
+func (c *Completion) Wait() (int, error) {
+	return c.Bytes(), c.Err()
+}
+
+// Block until the operation has completed or ctx is done. If ctx finishes
+// first its error is returned and the operation is abandoned: librbd still
+// owns it and it is cleaned up when it eventually completes, but read data is
+// no longer delivered, so the caller's buffers may be reused immediately
+func (c *Completion) WaitContext(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// The operation may have completed while we were acquiring the lock, in
+	// which case read data has already been delivered
+	if c.completed {
+		return c.Err()
+	}
+
+	c.abandoned = true
+
+	return ctx.Err()
+}
+
+// Start an asynchronous read into buf. buf must not be accessed until the
+// returned Completion has completed
+func (image *Image) AioRead(offset uint64, buf []byte) (*Completion, error) {
+	c := newCompletion(image, aioRead, offset)
+	c.bufs = [][]byte{buf}
+	c.stage(c.bufs, false)
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := C.rbd_aio_read(image.handle, C.uint64_t(offset), C.size_t(len(buf)), (*C.char)(c.cbuf), handle); result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to read from image '%s' at offset %d", image.name, offset)
+	}
+
+	return c, nil
+}
+
+// Start an asynchronous write of data. data is copied before AioWrite()
+// returns, so the caller may reuse it immediately
+func (image *Image) AioWrite(offset uint64, data []byte) (*Completion, error) {
+	c := newCompletion(image, aioWrite, offset)
+	c.stage([][]byte{data}, true)
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := C.rbd_aio_write(image.handle, C.uint64_t(offset), C.size_t(len(data)), (*C.char)(c.cbuf), handle); result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to write to image '%s' at offset %d", image.name, offset)
+	}
+
+	return c, nil
+}
+
+// Start an asynchronous read scattered across bufs, filling each in turn from
+// offset. None of bufs may be accessed until the Completion has completed
+func (image *Image) AioReadv(offset uint64, bufs [][]byte) (*Completion, error) {
+	c := newCompletion(image, aioRead, offset)
+	c.bufs = bufs
+	c.stage(bufs, false)
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := C.rbd_aio_readv(image.handle, c.iov, C.int(len(bufs)), C.uint64_t(offset), handle); result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to read from image '%s' at offset %d", image.name, offset)
+	}
+
+	return c, nil
+}
+
+// Start an asynchronous write gathered from bufs, written contiguously from
+// offset. bufs are copied before AioWritev() returns
+func (image *Image) AioWritev(offset uint64, bufs [][]byte) (*Completion, error) {
+	c := newCompletion(image, aioWrite, offset)
+	c.stage(bufs, true)
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := C.rbd_aio_writev(image.handle, c.iov, C.int(len(bufs)), C.uint64_t(offset), handle); result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to write to image '%s' at offset %d", image.name, offset)
+	}
+
+	return c, nil
+}
+
+// Start an asynchronous discard of length bytes at offset
+func (image *Image) AioDiscard(offset uint64, length uint64) (*Completion, error) {
+	c := newCompletion(image, aioDiscard, offset)
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := C.rbd_aio_discard(image.handle, C.uint64_t(offset), C.uint64_t(length), handle); result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to discard %d bytes at offset %d of image '%s'", length, offset, image.name)
+	}
+
+	return c, nil
+}
+
+// Start an asynchronous flush, which completes once all writes submitted
+// before it are durable
+func (image *Image) AioFlush() (*Completion, error) {
+	c := newCompletion(image, aioFlush, 0)
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := C.rbd_aio_flush(image.handle, handle); result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to flush image '%s'", image.name)
+	}
+
+	return c, nil
+}
+
+// Start an asynchronous compare and write. If the image contents at offset
+// do not match cmp, the Completion fails with ErrCompareMismatch and
+// MismatchOffset() reports where. Both buffers are copied before returning
+func (image *Image) AioCompareAndWrite(offset uint64, cmp []byte, data []byte) (*Completion, error) {
+	if len(cmp) != len(data) {
+		return nil, errors.New("Compare and write buffers must be the same length")
+	}
+
+	c := newCompletion(image, aioCompareAndWrite, offset)
+	c.stage([][]byte{cmp, data}, true)
+	c.cmismatch = (*C.uint64_t)(C.calloc(1, C.sizeof_uint64_t))
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	result := C.rbd_aio_compare_and_write(image.handle, C.uint64_t(offset), C.size_t(len(data)),
+		(*C.char)(c.cbuf), (*C.char)(unsafe.Add(c.cbuf, len(cmp))), handle, c.cmismatch, 0)
+	if result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to compare and write at offset %d of image '%s'", offset, image.name)
+	}
+
+	return c, nil
+}
+
+// Start opening an image asynchronously. Once the Completion has completed,
+// Image() returns the opened image
+func AioOpenImage(pool IOContext, name string) (*Completion, error) {
+	return aioOpenImage(pool, name, false)
+}
+
+// Read-only variant of AioOpenImage()
+func AioOpenImageRO(pool IOContext, name string) (*Completion, error) {
+	return aioOpenImage(pool, name, true)
+}
+
+func aioOpenImage(pool IOContext, name string, readonly bool) (*Completion, error) {
+	c := newCompletion(&Image{name: name, readonly: readonly}, aioOpen, 0)
+	c.chandle = (*C.rbd_image_t)(C.calloc(1, C.sizeof_rbd_image_t))
+
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	var result C.int
+
+	if readonly {
+		result = C.rbd_aio_open_read_only(C.rados_ioctx_t(pool.Handle()), c_name, c.chandle, nil, handle)
+	} else {
+		result = C.rbd_aio_open(C.rados_ioctx_t(pool.Handle()), c_name, c.chandle, nil, handle)
+	}
+
+	if result < 0 {
+		c.abort(handle)
+		return nil, errors.New("Failed to open RBD image")
+	}
+
+	return c, nil
+}
+
+// Start closing the image asynchronously. The image must not be used once
+// AioClose() has been called; after a successful close, Close() does nothing
+func (image *Image) AioClose() (*Completion, error) {
+	c := newCompletion(image, aioClose, 0)
+
+	handle, err := c.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := C.rbd_aio_close(image.handle, handle); result < 0 {
+		c.abort(handle)
+		return nil, fmt.Errorf("Unable to close image '%s'", image.name)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,97 @@
+package gorbd
+
+import (
+	"sync"
+)
+
+// Tracks a set of in-flight asynchronous operations. Like errgroup, the
+// first error seen is remembered and returned by Wait(), after which the
+// remaining operations are still waited for but their errors are dropped
+type AioGroup struct {
+	lock    sync.Mutex
+	pending []*Completion
+	err     error
+}
+
+func NewAioGroup() *AioGroup {
+	return &AioGroup{}
+}
+
+func (group *AioGroup) Add(c *Completion) {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	group.pending = append(group.pending, c)
+}
+
+// Returns the number of tracked operations that have not yet been reaped
+func (group *AioGroup) Pending() int {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	group.reap()
+
+	return len(group.pending)
+}
+
+// Returns the first error seen so far, without waiting
+func (group *AioGroup) Err() error {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	group.reap()
+
+	return group.err
+}
+
+// Block until at most n tracked operations remain in flight, returning the
+// first error seen. WaitN() can be used to bound queue depth when submitting
+func (group *AioGroup) WaitN(n int) error {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	for {
+		group.reap()
+
+		if len(group.pending) <= n {
+			return group.err
+		}
+
+		// Operations mostly complete in submission order, so the oldest is
+		// the best one to block on
+		oldest := group.pending[0]
+
+		group.lock.Unlock()
+		<-oldest.Done()
+		group.lock.Lock()
+	}
+}
+
+// Block until all tracked operations have completed, returning the first
+// error seen
+func (group *AioGroup) Wait() error {
+	return group.WaitN(0)
+}
+
+// Drop completed operations from the pending list, recording any error.
+// Must be called with the lock held
+func (group *AioGroup) reap() {
+	pending := group.pending[:0]
+
+	for _, c := range group.pending {
+		if !c.IsComplete() {
+			pending = append(pending, c)
+			continue
+		}
+
+		if err := c.Err(); err != nil && group.err == nil {
+			group.err = err
+		}
+	}
+
+	for i := len(pending); i < len(group.pending); i++ {
+		group.pending[i] = nil
+	}
+
+	group.pending = pending
+}
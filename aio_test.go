@@ -0,0 +1,283 @@
+package gorbd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	rados "github.com/clbh/go-rados"
+)
+
+// These tests exercise the AIO buffer ownership rules under GC pressure, and
+// need a running cluster. Set RBD_TEST_POOL to the pool to use; the default
+// ceph.conf is read. Run them as
+//
+//   GOGC=1 GODEBUG=cgocheck=1 RBD_TEST_POOL=rbd go test -run Aio
+//
+// so that any Go pointer retained by librbd, or any buffer touched after it
+// was handed back, shows up as a cgo check failure or corrupted data
+
+const aioTestImageSize = 4 << 20
+
+func openTestImage(t *testing.T) (*Image, func()) {
+	poolName := os.Getenv("RBD_TEST_POOL")
+	if poolName == "" {
+		t.Skip("RBD_TEST_POOL not set")
+	}
+
+	conn, err := rados.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ReadDefaultConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := conn.OpenPool(poolName)
+	if err != nil {
+		conn.Shutdown()
+		t.Fatal(err)
+	}
+
+	name := fmt.Sprintf("go-rbd-aio-test-%d", time.Now().UnixNano())
+	if err := CreateImage(pool, name, aioTestImageSize, 22); err != nil {
+		pool.Destroy()
+		conn.Shutdown()
+		t.Fatal(err)
+	}
+
+	image, err := OpenImage(pool, name)
+	if err != nil {
+		RemoveImage(pool, name)
+		pool.Destroy()
+		conn.Shutdown()
+		t.Fatal(err)
+	}
+
+	return image, func() {
+		image.Close()
+		RemoveImage(pool, name)
+		pool.Destroy()
+		conn.Shutdown()
+	}
+}
+
+// Run the garbage collector continuously until the returned function is
+// called
+func gcStress() func() {
+	previous := debug.SetGCPercent(1)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		debug.SetGCPercent(previous)
+	}
+}
+
+func pattern(seed byte, length int) []byte {
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i] = seed + byte(i*7)
+	}
+
+	return buf
+}
+
+func TestAioWritevReadv(t *testing.T) {
+	image, cleanup := openTestImage(t)
+	defer cleanup()
+	defer gcStress()()
+
+	want := [][]byte{pattern(1, 4096), pattern(2, 512), pattern(3, 8192)}
+
+	// Writes are staged before AioWritev() returns, so the caller's buffers
+	// may be scribbled over straight away
+	bufs := [][]byte{append([]byte{}, want[0]...), append([]byte{}, want[1]...), append([]byte{}, want[2]...)}
+	c, err := image.AioWritev(0, bufs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range bufs {
+		for i := range buf {
+			buf[i] = 0xff
+		}
+	}
+	bufs = nil
+	runtime.GC()
+
+	if _, err := c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := [][]byte{make([]byte, 4096), make([]byte, 512), make([]byte, 8192)}
+	c, err = image.AioReadv(0, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+
+	n, err := c.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4096+512+8192 {
+		t.Fatalf("read %d bytes", n)
+	}
+
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("buffer %d does not match what was written", i)
+		}
+	}
+}
+
+func TestAioRead(t *testing.T) {
+	image, cleanup := openTestImage(t)
+	defer cleanup()
+	defer gcStress()()
+
+	want := pattern(9, 65536)
+	if _, err := image.Write(4096, want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Many reads in flight at once, each buffer only reachable through its
+	// Completion
+	completions := make([]*Completion, 0, 32)
+	for i := 0; i < 32; i++ {
+		c, err := image.AioRead(4096, make([]byte, len(want)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		completions = append(completions, c)
+		runtime.GC()
+	}
+
+	for i, c := range completions {
+		if _, err := c.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c.bufs[0], want) {
+			t.Fatalf("read %d does not match what was written", i)
+		}
+	}
+}
+
+func TestAioCompareAndWrite(t *testing.T) {
+	image, cleanup := openTestImage(t)
+	defer cleanup()
+	defer gcStress()()
+
+	old := pattern(4, 512)
+	if _, err := image.Write(0, old); err != nil {
+		t.Fatal(err)
+	}
+
+	data := pattern(5, 512)
+	c, err := image.AioCompareAndWrite(0, append([]byte{}, old...), append([]byte{}, data...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+
+	if _, err := c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, 512)
+	if _, err := image.Read(0, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("compare and write did not write its data")
+	}
+
+	// The image now holds data, so comparing against old fails at the first
+	// byte that differs
+	c, err = image.AioCompareAndWrite(0, old, pattern(6, 512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+
+	if err := c.Err(); err != ErrCompareMismatch {
+		t.Fatalf("expected ErrCompareMismatch, got %v", err)
+	}
+
+	var first uint64
+	for first < 512 && old[first] == data[first] {
+		first++
+	}
+	if c.MismatchOffset() != first {
+		t.Fatalf("mismatch reported at %d, expected %d", c.MismatchOffset(), first)
+	}
+}
+
+func TestAioWaitContextAbandon(t *testing.T) {
+	image, cleanup := openTestImage(t)
+	defer cleanup()
+	defer gcStress()()
+
+	want := pattern(7, 1<<20)
+	if _, err := image.Write(0, want); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i := 0; i < 16; i++ {
+		buf := bytes.Repeat([]byte{0xaa}, len(want))
+
+		c, err := image.AioRead(0, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runtime.GC()
+
+		err = c.WaitContext(ctx)
+
+		// Once WaitContext() has returned the buffer belongs to the caller
+		// again, whichever way the race went
+		<-c.Done()
+		runtime.GC()
+
+		// Either the wait was abandoned and the buffer never touched, or
+		// the read completed first and its data was delivered in full
+		switch err {
+		case context.Canceled:
+			if !bytes.Equal(buf, bytes.Repeat([]byte{0xaa}, len(want))) {
+				t.Fatal("abandoned read wrote to the caller's buffer")
+			}
+		case nil:
+			if c.Bytes() != len(want) || !bytes.Equal(buf, want) {
+				t.Fatal("completed read does not match what was written")
+			}
+		default:
+			t.Fatalf("expected context.Canceled or nil, got %v", err)
+		}
+	}
+}
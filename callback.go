@@ -0,0 +1,102 @@
+package gorbd
+
+// #include <stdint.h>
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Progress callback used by long-running image operations. It is passed the
+// amount of work done so far and the total amount of work; returning a
+// non-zero value aborts the operation
+type ProgressFunc func(offset, total uint64) int
+
+// Go values cannot be handed to C directly, so callbacks are kept in a
+// registry and C is given an opaque ID to pass back to us instead
+var (
+	callbackLock   sync.Mutex
+	callbackNextID uintptr
+	callbacks      = make(map[uintptr]interface{})
+)
+
+func registerCallback(value interface{}) uintptr {
+	callbackLock.Lock()
+	defer callbackLock.Unlock()
+
+	callbackNextID++
+	callbacks[callbackNextID] = value
+
+	return callbackNextID
+}
+
+func lookupCallback(id uintptr) interface{} {
+	callbackLock.Lock()
+	defer callbackLock.Unlock()
+
+	return callbacks[id]
+}
+
+func unregisterCallback(id uintptr) {
+	callbackLock.Lock()
+	defer callbackLock.Unlock()
+
+	delete(callbacks, id)
+}
+
+//export goProgressCallback
+func goProgressCallback(offset C.uint64_t, total C.uint64_t, arg unsafe.Pointer) C.int {
+	fn, _ := lookupCallback(uintptr(arg)).(ProgressFunc)
+	if fn == nil {
+		return 0
+	}
+
+	return C.int(fn(uint64(offset), uint64(total)))
+}
+
+type readIterateState struct {
+	fn  func(offset uint64, data []byte) error
+	err error
+}
+
+//export goReadIterateCallback
+func goReadIterateCallback(offset C.uint64_t, length C.size_t, buf *C.char, arg unsafe.Pointer) C.int {
+	state, _ := lookupCallback(uintptr(arg)).(*readIterateState)
+
+	// librbd passes a nil buffer for unallocated extents
+	if state == nil || buf == nil {
+		return 0
+	}
+
+	if err := state.fn(uint64(offset), C.GoBytes(unsafe.Pointer(buf), C.int(length))); err != nil {
+		state.err = err
+		return -1
+	}
+
+	return 0
+}
+
+// A changed region of an image, as reported by DiffSnapshots(). Exists is
+// false for regions which were discarded or are otherwise now zero
+type Extent struct {
+	Offset uint64
+	Length uint64
+	Exists bool
+}
+
+//export goDiffIterateCallback
+func goDiffIterateCallback(offset C.uint64_t, length C.size_t, exists C.int, arg unsafe.Pointer) C.int {
+	extents, _ := lookupCallback(uintptr(arg)).(*[]Extent)
+	if extents == nil {
+		return 0
+	}
+
+	*extents = append(*extents, Extent{
+		Offset: uint64(offset),
+		Length: uint64(length),
+		Exists: exists != 0,
+	})
+
+	return 0
+}
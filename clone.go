@@ -0,0 +1,309 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Identifies an image taking part in a clone relationship
+type LinkedImageSpec struct {
+	PoolID    int64
+	PoolName  string
+	Namespace string
+	ImageID   string
+	ImageName string
+	Trash     bool
+}
+
+// Identifies the parent snapshot of a clone
+type SnapSpec struct {
+	ID            uint64
+	NamespaceType int
+	Name          string
+}
+
+type ParentSpec struct {
+	Image LinkedImageSpec
+	Snap  SnapSpec
+}
+
+func newLinkedImageSpec(spec *C.rbd_linked_image_spec_t) LinkedImageSpec {
+	return LinkedImageSpec{
+		PoolID:    int64(spec.pool_id),
+		PoolName:  C.GoString(spec.pool_name),
+		Namespace: C.GoString(spec.pool_namespace),
+		ImageID:   C.GoString(spec.image_id),
+		ImageName: C.GoString(spec.image_name),
+		Trash:     bool(spec.trash),
+	}
+}
+
+// Returns the parent image and snapshot of a clone, or nil if the image is
+// not a clone
+func (image *Image) GetParent() (*ParentSpec, error) {
+	var parentImage C.rbd_linked_image_spec_t
+	var parentSnap C.rbd_snap_spec_t
+
+	result := C.rbd_get_parent(image.handle, &parentImage, &parentSnap)
+	if result == -C.ENOENT {
+		return nil, nil
+	}
+	if result < 0 {
+		return nil, fmt.Errorf("Unable to get parent of image '%s'", image.name)
+	}
+	defer C.rbd_linked_image_spec_cleanup(&parentImage)
+	defer C.rbd_snap_spec_cleanup(&parentSnap)
+
+	return &ParentSpec{
+		Image: newLinkedImageSpec(&parentImage),
+		Snap: SnapSpec{
+			ID:            uint64(parentSnap.id),
+			NamespaceType: int(parentSnap.namespace_type),
+			Name:          C.GoString(parentSnap.name),
+		},
+	}, nil
+}
+
+// Returns the clones of the snapshot the image handle is set to. The handle
+// must have been opened at, or pointed to, a snapshot
+func (image *Image) ListChildren() ([]LinkedImageSpec, error) {
+	children, err := listLinkedImages(func(specs *C.rbd_linked_image_spec_t, max *C.size_t) C.int {
+		return C.rbd_list_children3(image.handle, specs, max)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list children of image '%s'", image.name)
+	}
+
+	return children, nil
+}
+
+// Returns every image in the clone tree below the image, at any depth. If
+// the handle is set to a snapshot, only that snapshot's descendants are
+// returned
+func (image *Image) ListDescendants() ([]LinkedImageSpec, error) {
+	descendants, err := listLinkedImages(func(specs *C.rbd_linked_image_spec_t, max *C.size_t) C.int {
+		return C.rbd_list_descendants(image.handle, specs, max)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list descendants of image '%s'", image.name)
+	}
+
+	return descendants, nil
+}
+
+func listLinkedImages(list func(*C.rbd_linked_image_spec_t, *C.size_t) C.int) ([]LinkedImageSpec, error) {
+	max := C.size_t(16)
+
+	for {
+		specs := make([]C.rbd_linked_image_spec_t, max)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := list(&specs[0], &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Failed to list linked images: %d", int(result))
+		}
+
+		images := make([]LinkedImageSpec, 0, int(max))
+		for i := range specs[:max] {
+			images = append(images, newLinkedImageSpec(&specs[i]))
+		}
+
+		C.rbd_linked_image_spec_list_cleanup(&specs[0], max)
+
+		return images, nil
+	}
+}
+
+// Returns the ancestry of a clone, from its immediate parent up to the root
+// image that is not itself a clone. pool may be any pool in the image's
+// cluster; ancestors are opened by ID in whichever pool and namespace they
+// live in, so parents that have been moved to the trash are followed too
+func (image *Image) ParentChain(pool IOContext) ([]ParentSpec, error) {
+	cluster := C.rados_ioctx_get_cluster(C.rados_ioctx_t(pool.Handle()))
+	chain := make([]ParentSpec, 0)
+
+	parent, err := image.GetParent()
+	for parent != nil && err == nil {
+		chain = append(chain, *parent)
+		parent, err = getParentOfParent(cluster, parent)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// Open the parent snapshot described by spec and return its own parent
+func getParentOfParent(cluster C.rados_t, spec *ParentSpec) (*ParentSpec, error) {
+	parent, closer, err := openLinkedImage(cluster, spec.Image, true)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	// The parent's own linkage must be read at the snapshot, as the parent
+	// may have been flattened since
+	if result := C.rbd_snap_set_by_id(parent.handle, C.uint64_t(spec.Snap.ID)); result < 0 {
+		return nil, fmt.Errorf("Unable to set snapshot %d on parent image '%s'", spec.Snap.ID, spec.Image.ImageName)
+	}
+
+	return parent.GetParent()
+}
+
+// Open an image by ID in whichever pool and namespace it lives in. The
+// returned function closes the image and the pool handle opened for it
+func openLinkedImage(cluster C.rados_t, spec LinkedImageSpec, readonly bool) (*Image, func(), error) {
+	var ioctx C.rados_ioctx_t
+
+	if result := C.rados_ioctx_create2(cluster, C.int64_t(spec.PoolID), &ioctx); result < 0 {
+		return nil, nil, fmt.Errorf("Unable to open pool %d of image '%s'", spec.PoolID, spec.ImageName)
+	}
+
+	c_namespace := C.CString(spec.Namespace)
+	defer C.free(unsafe.Pointer(c_namespace))
+	C.rados_ioctx_set_namespace(ioctx, c_namespace)
+
+	c_id := C.CString(spec.ImageID)
+	defer C.free(unsafe.Pointer(c_id))
+
+	var handle C.rbd_image_t
+	var result C.int
+
+	if readonly {
+		result = C.rbd_open_by_id_read_only(ioctx, c_id, &handle, nil)
+	} else {
+		result = C.rbd_open_by_id(ioctx, c_id, &handle, nil)
+	}
+
+	if result < 0 {
+		C.rados_ioctx_destroy(ioctx)
+		return nil, nil, fmt.Errorf("Unable to open image '%s'", spec.ImageName)
+	}
+
+	image := &Image{handle: handle, name: spec.ImageName, readonly: readonly}
+
+	return image, func() {
+		image.Close()
+		C.rados_ioctx_destroy(ioctx)
+	}, nil
+}
+
+// Returned by RemoveImage2() when an image cannot be removed because clone v2
+// has deferred the removal of some of its snapshots to the trash namespace,
+// and those snapshots are still in use by the listed clones
+type DeferredSnapshotsError struct {
+	Image  string
+	Clones []LinkedImageSpec
+}
+
+func (err *DeferredSnapshotsError) Error() string {
+	return fmt.Sprintf("Unable to remove image '%s': %d clones depend on its trashed snapshots", err.Image, len(err.Clones))
+}
+
+// Remove an image, as RemoveImage(). If removal fails because clones still
+// depend on snapshots in the trash namespace, a *DeferredSnapshotsError is
+// returned, unless flattenClones is set, in which case those clones are
+// flattened and the removal retried
+func RemoveImage2(pool IOContext, imageName string, flattenClones bool) error {
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	result := C.rbd_remove(C.rados_ioctx_t(pool.Handle()), c_imageName)
+	if result >= 0 {
+		return nil
+	}
+
+	// Only these indicate snapshots that clones still depend on; anything
+	// else (a missing image, watchers, permissions) is not ours to resolve
+	if result != -C.ENOTEMPTY && result != -C.ECHILD {
+		return fmt.Errorf("Unable to remove image '%s'", imageName)
+	}
+
+	cluster := C.rados_ioctx_get_cluster(C.rados_ioctx_t(pool.Handle()))
+
+	clones, err := deferredSnapshotClones(pool, cluster, imageName)
+	if err != nil {
+		return err
+	}
+	if len(clones) == 0 {
+		return fmt.Errorf("Unable to remove image '%s'", imageName)
+	}
+	if !flattenClones {
+		return &DeferredSnapshotsError{Image: imageName, Clones: clones}
+	}
+
+	for _, clone := range clones {
+		image, closer, err := openLinkedImage(cluster, clone, false)
+		if err != nil {
+			return err
+		}
+
+		err = image.Flatten(nil)
+		closer()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// Flattening the last clone of a trashed snapshot removes the snapshot
+	if result := C.rbd_remove(C.rados_ioctx_t(pool.Handle()), c_imageName); result < 0 {
+		return fmt.Errorf("Unable to remove image '%s'", imageName)
+	}
+
+	return nil
+}
+
+// Returns the direct clones of an image whose parent snapshot is in the trash
+// namespace
+func deferredSnapshotClones(pool IOContext, cluster C.rados_t, imageName string) ([]LinkedImageSpec, error) {
+	image, err := OpenImageRO(pool, imageName)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	descendants, err := image.ListDescendants()
+	if err != nil {
+		return nil, err
+	}
+
+	poolID := int64(C.rados_ioctx_get_id(C.rados_ioctx_t(pool.Handle())))
+	namespace, err := poolNamespace(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	clones := make([]LinkedImageSpec, 0)
+
+	for _, descendant := range descendants {
+		child, closer, err := openLinkedImage(cluster, descendant, true)
+		if err != nil {
+			return nil, err
+		}
+
+		parent, err := child.GetParent()
+		closer()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if parent != nil && parent.Image.PoolID == poolID && parent.Image.Namespace == namespace &&
+			parent.Image.ImageName == imageName && parent.Snap.NamespaceType == RBD_SNAP_NAMESPACE_TYPE_TRASH {
+			clones = append(clones, descendant)
+		}
+	}
+
+	return clones, nil
+}
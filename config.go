@@ -0,0 +1,142 @@
+package gorbd
+
+// #include <errno.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Where the effective value of a configuration option comes from
+const (
+	RBD_CONFIG_SOURCE_CONFIG = C.RBD_CONFIG_SOURCE_CONFIG
+	RBD_CONFIG_SOURCE_POOL   = C.RBD_CONFIG_SOURCE_POOL
+	RBD_CONFIG_SOURCE_IMAGE  = C.RBD_CONFIG_SOURCE_IMAGE
+)
+
+// A librbd client configuration option and its effective value
+type ConfigOption struct {
+	Name   string
+	Value  string
+	Source int
+}
+
+// Returns the effective librbd client configuration for images in the pool,
+// with the source of each value. Options overridden for the pool report
+// RBD_CONFIG_SOURCE_POOL
+func PoolConfigList(pool IOContext) ([]ConfigOption, error) {
+	config, err := listConfig(func(options *C.rbd_config_option_t, max *C.int) C.int {
+		return C.rbd_config_pool_list(C.rados_ioctx_t(pool.Handle()), options, max)
+	}, func(options *C.rbd_config_option_t, max C.int) {
+		C.rbd_config_pool_list_cleanup(options, max)
+	})
+	if err != nil {
+		return nil, errors.New("Failed to list pool configuration")
+	}
+
+	return config, nil
+}
+
+// Returns the effective librbd client configuration for the image, with the
+// source of each value: the client configuration, the pool or the image
+func (image *Image) ConfigList() ([]ConfigOption, error) {
+	config, err := listConfig(func(options *C.rbd_config_option_t, max *C.int) C.int {
+		return C.rbd_config_image_list(image.handle, options, max)
+	}, func(options *C.rbd_config_option_t, max C.int) {
+		C.rbd_config_image_list_cleanup(options, max)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list configuration of image '%s'", image.name)
+	}
+
+	return config, nil
+}
+
+func listConfig(list func(*C.rbd_config_option_t, *C.int) C.int, cleanup func(*C.rbd_config_option_t, C.int)) ([]ConfigOption, error) {
+	max := C.int(64)
+
+	for {
+		options := make([]C.rbd_config_option_t, max)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := list(&options[0], &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Failed to list configuration: %d", int(result))
+		}
+
+		config := make([]ConfigOption, 0, int(max))
+		for _, option := range options[:max] {
+			config = append(config, ConfigOption{
+				Name:   C.GoString(option.name),
+				Value:  C.GoString(option.value),
+				Source: int(option.source),
+			})
+		}
+
+		cleanup(&options[0], max)
+
+		return config, nil
+	}
+}
+
+// Build a set of image creation options from the rbd_default_* settings in
+// effect for a pool, so that new images follow the configuration operators
+// have already applied cluster-side. The caller must Destroy() the result
+func DefaultImageOptions(pool IOContext) (*ImageOptions, error) {
+	config, err := PoolConfigList(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := NewImageOptions()
+
+	for _, option := range config {
+		var err error
+
+		switch option.Name {
+		case "rbd_default_format":
+			err = setUint64Option(opts, RBD_IMAGE_OPTION_FORMAT, option.Value)
+		case "rbd_default_order":
+			err = setUint64Option(opts, RBD_IMAGE_OPTION_ORDER, option.Value)
+		case "rbd_default_stripe_unit":
+			err = setUint64Option(opts, RBD_IMAGE_OPTION_STRIPE_UNIT, option.Value)
+		case "rbd_default_stripe_count":
+			err = setUint64Option(opts, RBD_IMAGE_OPTION_STRIPE_COUNT, option.Value)
+		case "rbd_default_features":
+			var features uint64
+			if features, err = parseFeatures(option.Value); err == nil {
+				err = opts.SetUint64(RBD_IMAGE_OPTION_FEATURES, features)
+			}
+		case "rbd_default_data_pool":
+			if option.Value != "" {
+				err = opts.SetDataPool(option.Value)
+			}
+		}
+
+		if err != nil {
+			opts.Destroy()
+			return nil, fmt.Errorf("Invalid pool setting %s='%s': %v", option.Name, option.Value, err)
+		}
+	}
+
+	return opts, nil
+}
+
+// Zero values are left unset so that librbd applies its own defaults
+func setUint64Option(opts *ImageOptions, option int, value string) error {
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	if v == 0 {
+		return nil
+	}
+
+	return opts.SetUint64(option, v)
+}
@@ -0,0 +1,80 @@
+package gorbd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Feature names as used by librbd and the rbd CLI, in bit order
+var featureNames = []struct {
+	name string
+	bit  uint64
+}{
+	{"layering", RBD_FEATURE_LAYERING},
+	{"striping", RBD_FEATURE_STRIPINGV2},
+	{"exclusive-lock", RBD_FEATURE_EXCLUSIVE_LOCK},
+	{"object-map", RBD_FEATURE_OBJECT_MAP},
+	{"fast-diff", RBD_FEATURE_FAST_DIFF},
+	{"deep-flatten", RBD_FEATURE_DEEP_FLATTEN},
+	{"journaling", RBD_FEATURE_JOURNALING},
+	{"data-pool", RBD_FEATURE_DATA_POOL},
+	{"operations", RBD_FEATURE_OPERATIONS},
+	{"migrating", RBD_FEATURE_MIGRATING},
+	{"non-primary", RBD_FEATURE_NON_PRIMARY},
+}
+
+// Returns the names of the features set in a feature bitmask, in bit order.
+// Bits without a known name are ignored
+func FeaturesToNames(features uint64) []string {
+	names := make([]string, 0)
+
+	for _, feature := range featureNames {
+		if features&feature.bit != 0 {
+			names = append(names, feature.name)
+		}
+	}
+
+	return names
+}
+
+// Returns the feature bitmask for a list of feature names, such as
+// "exclusive-lock" or "object-map"
+func FeaturesFromNames(names []string) (uint64, error) {
+	var features uint64
+
+	for _, name := range names {
+		found := false
+
+		for _, feature := range featureNames {
+			if feature.name == name {
+				features |= feature.bit
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("Unknown image feature '%s'", name)
+		}
+	}
+
+	return features, nil
+}
+
+// Parse a feature setting as found in the rbd_default_features option, which
+// may either be a numeric bitmask or a comma-separated list of feature names
+func parseFeatures(value string) (uint64, error) {
+	if features, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return features, nil
+	}
+
+	names := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return FeaturesFromNames(names)
+}
@@ -0,0 +1,397 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+//
+// extern int goProgressCallback(uint64_t, uint64_t, void*);
+//
+// static inline int _rbd_group_snap_rollback_with_progress(rados_ioctx_t group_p, const char *group_name, const char *snap_name, uintptr_t arg) {
+// 	return rbd_group_snap_rollback_with_progress(group_p, group_name, snap_name, goProgressCallback, (void*)arg);
+// }
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// A consistency group: a set of images, possibly in different pools, that can
+// be snapshotted together at a single crash-consistent point
+type Group struct {
+	pool IOContext
+	name string
+}
+
+// Returns a handle for an existing group. librbd has no group handles of its
+// own, so this does not check that the group exists
+func NewGroup(pool IOContext, name string) *Group {
+	return &Group{pool: pool, name: name}
+}
+
+func (group *Group) Name() string {
+	return group.name
+}
+
+func (group *Group) Pool() IOContext {
+	return group.pool
+}
+
+func CreateGroup(pool IOContext, name string) (*Group, error) {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_group_create(C.rados_ioctx_t(pool.Handle()), c_name); result < 0 {
+		return nil, fmt.Errorf("Unable to create group '%s'", name)
+	}
+
+	return NewGroup(pool, name), nil
+}
+
+// Remove a group. Its member images are removed from the group, but not
+// deleted
+func RemoveGroup(pool IOContext, name string) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_group_remove(C.rados_ioctx_t(pool.Handle()), c_name); result < 0 {
+		return fmt.Errorf("Unable to remove group '%s'", name)
+	}
+
+	return nil
+}
+
+func RenameGroup(pool IOContext, srcName string, dstName string) error {
+	c_srcName := C.CString(srcName)
+	defer C.free(unsafe.Pointer(c_srcName))
+	c_dstName := C.CString(dstName)
+	defer C.free(unsafe.Pointer(c_dstName))
+
+	if result := C.rbd_group_rename(C.rados_ioctx_t(pool.Handle()), c_srcName, c_dstName); result < 0 {
+		return fmt.Errorf("Unable to rename group '%s' to '%s'", srcName, dstName)
+	}
+
+	return nil
+}
+
+func ListGroups(pool IOContext) ([]string, error) {
+	size := C.size_t(1024)
+
+	for {
+		buf := make([]C.char, size)
+
+		// On -ERANGE, size is updated to the length required
+		result := C.rbd_group_list(C.rados_ioctx_t(pool.Handle()), &buf[0], &size)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list groups")
+		}
+
+		return splitStringList(buf[:size]), nil
+	}
+}
+
+// Add the named image in pool to the group. An image may belong to at most
+// one group
+func (group *Group) AddImage(pool IOContext, imageName string) error {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	if result := C.rbd_group_image_add(C.rados_ioctx_t(group.pool.Handle()), c_groupName,
+		C.rados_ioctx_t(pool.Handle()), c_imageName); result < 0 {
+		return fmt.Errorf("Unable to add image '%s' to group '%s'", imageName, group.name)
+	}
+
+	return nil
+}
+
+func (group *Group) RemoveImage(pool IOContext, imageName string) error {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	if result := C.rbd_group_image_remove(C.rados_ioctx_t(group.pool.Handle()), c_groupName,
+		C.rados_ioctx_t(pool.Handle()), c_imageName); result < 0 {
+		return fmt.Errorf("Unable to remove image '%s' from group '%s'", imageName, group.name)
+	}
+
+	return nil
+}
+
+type GroupImageState int
+
+const (
+	RBD_GROUP_IMAGE_STATE_ATTACHED   GroupImageState = C.RBD_GROUP_IMAGE_STATE_ATTACHED
+	RBD_GROUP_IMAGE_STATE_INCOMPLETE GroupImageState = C.RBD_GROUP_IMAGE_STATE_INCOMPLETE
+)
+
+// A member image of a group. An image is RBD_GROUP_IMAGE_STATE_INCOMPLETE
+// while it is being added or removed, or if that was interrupted
+type GroupImageInfo struct {
+	PoolID int64
+	Name   string
+	State  GroupImageState
+}
+
+func (group *Group) ListImages() ([]GroupImageInfo, error) {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	max := C.size_t(16)
+
+	for {
+		infos := make([]C.rbd_group_image_info_t, max)
+		infoSize := C.size_t(C.sizeof_rbd_group_image_info_t)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := C.rbd_group_image_list(C.rados_ioctx_t(group.pool.Handle()), c_groupName, &infos[0], infoSize, &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list images of group '%s'", group.name)
+		}
+
+		images := make([]GroupImageInfo, 0, int(max))
+		for _, info := range infos[:max] {
+			images = append(images, GroupImageInfo{
+				PoolID: int64(info.pool),
+				Name:   C.GoString(info.name),
+				State:  GroupImageState(info.state),
+			})
+		}
+
+		C.rbd_group_image_list_cleanup(&infos[0], infoSize, max)
+
+		return images, nil
+	}
+}
+
+////
+//   Group snapshots
+////
+
+type GroupSnapState int
+
+const (
+	RBD_GROUP_SNAP_STATE_INCOMPLETE GroupSnapState = C.RBD_GROUP_SNAP_STATE_INCOMPLETE
+	RBD_GROUP_SNAP_STATE_COMPLETE   GroupSnapState = C.RBD_GROUP_SNAP_STATE_COMPLETE
+)
+
+type GroupSnapInfo struct {
+	Name  string
+	State GroupSnapState
+}
+
+// Take a snapshot of every image in the group at a single crash-consistent
+// point
+func (group *Group) CreateSnapshot(name string) error {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_group_snap_create(C.rados_ioctx_t(group.pool.Handle()), c_groupName, c_name); result < 0 {
+		return fmt.Errorf("Unable to create snapshot '%s' of group '%s'", name, group.name)
+	}
+
+	return nil
+}
+
+// As CreateSnapshot(), with RBD_SNAP_CREATE_* flags controlling how quiesce
+// watchers on the member images are notified
+func (group *Group) CreateSnapshot2(name string, flags uint32) error {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_group_snap_create2(C.rados_ioctx_t(group.pool.Handle()), c_groupName, c_name, C.uint32_t(flags)); result < 0 {
+		return fmt.Errorf("Unable to create snapshot '%s' of group '%s'", name, group.name)
+	}
+
+	return nil
+}
+
+func (group *Group) RemoveSnapshot(name string) error {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_group_snap_remove(C.rados_ioctx_t(group.pool.Handle()), c_groupName, c_name); result < 0 {
+		return fmt.Errorf("Unable to remove snapshot '%s' of group '%s'", name, group.name)
+	}
+
+	return nil
+}
+
+func (group *Group) RenameSnapshot(srcName string, dstName string) error {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_srcName := C.CString(srcName)
+	defer C.free(unsafe.Pointer(c_srcName))
+	c_dstName := C.CString(dstName)
+	defer C.free(unsafe.Pointer(c_dstName))
+
+	if result := C.rbd_group_snap_rename(C.rados_ioctx_t(group.pool.Handle()), c_groupName, c_srcName, c_dstName); result < 0 {
+		return fmt.Errorf("Unable to rename snapshot '%s' of group '%s' to '%s'", srcName, group.name, dstName)
+	}
+
+	return nil
+}
+
+func (group *Group) ListSnapshots() ([]GroupSnapInfo, error) {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	max := C.size_t(16)
+
+	for {
+		infos := make([]C.rbd_group_snap_info_t, max)
+		infoSize := C.size_t(C.sizeof_rbd_group_snap_info_t)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := C.rbd_group_snap_list(C.rados_ioctx_t(group.pool.Handle()), c_groupName, &infos[0], infoSize, &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list snapshots of group '%s'", group.name)
+		}
+
+		snaps := make([]GroupSnapInfo, 0, int(max))
+		for _, info := range infos[:max] {
+			snaps = append(snaps, GroupSnapInfo{
+				Name:  C.GoString(info.name),
+				State: GroupSnapState(info.state),
+			})
+		}
+
+		C.rbd_group_snap_list_cleanup(&infos[0], infoSize, max)
+
+		return snaps, nil
+	}
+}
+
+// Roll every image in the group back to the group snapshot. Images should not
+// be in use while this runs. progress may be nil
+func (group *Group) RollbackSnapshot(name string, progress ProgressFunc) error {
+	c_groupName := C.CString(group.name)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	var result C.int
+
+	if progress == nil {
+		result = C.rbd_group_snap_rollback(C.rados_ioctx_t(group.pool.Handle()), c_groupName, c_name)
+	} else {
+		id := registerCallback(progress)
+		defer unregisterCallback(id)
+
+		result = C._rbd_group_snap_rollback_with_progress(C.rados_ioctx_t(group.pool.Handle()), c_groupName, c_name, C.uintptr_t(id))
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to roll back group '%s' to snapshot '%s'", group.name, name)
+	}
+
+	return nil
+}
+
+// The group an image belongs to
+type GroupInfo struct {
+	PoolID int64
+	Name   string
+}
+
+// Returns the group the image belongs to, or nil if it is not a member of
+// any group
+func (image *Image) GetGroup() (*GroupInfo, error) {
+	var info C.rbd_group_info_t
+
+	if result := C.rbd_get_group(image.handle, &info, C.sizeof_rbd_group_info_t); result < 0 {
+		return nil, fmt.Errorf("Unable to get group of image '%s'", image.name)
+	}
+	defer C.rbd_group_info_cleanup(&info, C.sizeof_rbd_group_info_t)
+
+	if info.pool == C.RBD_GROUP_INVALID_POOL {
+		return nil, nil
+	}
+
+	return &GroupInfo{
+		PoolID: int64(info.pool),
+		Name:   C.GoString(info.name),
+	}, nil
+}
+
+// An image snapshot taken as part of a group snapshot, together with the
+// group snapshot that owns it
+type ImageGroupSnapshot struct {
+	Snapshot SnapInfo
+	Group    SnapGroupNamespace
+}
+
+// Returns the image's snapshots that belong to group snapshots. These are
+// hidden from the user snapshot namespace and can only be removed by
+// removing the owning group snapshot
+func (image *Image) ListGroupSnapshots() ([]ImageGroupSnapshot, error) {
+	snaps, err := image.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	groupSnaps := make([]ImageGroupSnapshot, 0)
+
+	for _, snap := range snaps {
+		nsType, err := image.GetSnapNamespaceType(snap.ID)
+		if err != nil {
+			return nil, err
+		}
+		if nsType != RBD_SNAP_NAMESPACE_TYPE_GROUP {
+			continue
+		}
+
+		ns, err := image.GetSnapGroupNamespace(snap.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		groupSnaps = append(groupSnaps, ImageGroupSnapshot{Snapshot: snap, Group: *ns})
+	}
+
+	return groupSnaps, nil
+}
+
+// Look up the group snapshot that owns an image snapshot. pool must be the
+// pool with ID ns.GroupPool. Returns nil if the group or its snapshot no
+// longer exists, leaving the image snapshot orphaned
+func (ns *SnapGroupNamespace) GroupSnapshot(pool IOContext) (*GroupSnapInfo, error) {
+	if poolID := int64(C.rados_ioctx_get_id(C.rados_ioctx_t(pool.Handle()))); poolID != ns.GroupPool {
+		return nil, fmt.Errorf("Group '%s' is in pool %d, not pool %d", ns.GroupName, ns.GroupPool, poolID)
+	}
+
+	c_groupName := C.CString(ns.GroupName)
+	defer C.free(unsafe.Pointer(c_groupName))
+	c_snapName := C.CString(ns.GroupSnapName)
+	defer C.free(unsafe.Pointer(c_snapName))
+	var info C.rbd_group_snap_info2_t
+
+	result := C.rbd_group_snap_get_info(C.rados_ioctx_t(pool.Handle()), c_groupName, c_snapName, &info)
+	if result == -C.ENOENT {
+		return nil, nil
+	}
+	if result < 0 {
+		return nil, fmt.Errorf("Unable to get snapshot '%s' of group '%s'", ns.GroupSnapName, ns.GroupName)
+	}
+	defer C.rbd_group_snap_get_info_cleanup(&info)
+
+	return &GroupSnapInfo{
+		Name:  C.GoString(info.name),
+		State: GroupSnapState(info.state),
+	}, nil
+}
@@ -0,0 +1,379 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+////
+//   Advisory locks
+////
+
+// A client holding an advisory lock on an image
+type Locker struct {
+	Client string
+	Cookie string
+	Addr   string
+}
+
+type LockInfo struct {
+	Exclusive bool
+	Tag       string
+	Lockers   []Locker
+}
+
+func (image *Image) ListLockers() (*LockInfo, error) {
+	var exclusive C.int
+	tagSize := C.size_t(64)
+	clientsSize := C.size_t(256)
+	cookiesSize := C.size_t(256)
+	addrsSize := C.size_t(256)
+
+	for {
+		tag := make([]C.char, tagSize)
+		clients := make([]C.char, clientsSize)
+		cookies := make([]C.char, cookiesSize)
+		addrs := make([]C.char, addrsSize)
+
+		// On -ERANGE, every size is updated to the length required
+		result := C.rbd_list_lockers(image.handle, &exclusive,
+			&tag[0], &tagSize,
+			&clients[0], &clientsSize,
+			&cookies[0], &cookiesSize,
+			&addrs[0], &addrsSize)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list lockers of image '%s'", image.name)
+		}
+
+		info := &LockInfo{
+			Exclusive: exclusive != 0,
+			Lockers:   make([]Locker, 0, int(result)),
+		}
+
+		if result == 0 {
+			return info, nil
+		}
+
+		info.Tag = C.GoString(&tag[0])
+
+		clientList := splitStringList(clients[:clientsSize])
+		cookieList := splitStringList(cookies[:cookiesSize])
+		addrList := splitStringList(addrs[:addrsSize])
+
+		for i := 0; i < int(result) && i < len(clientList) && i < len(cookieList) && i < len(addrList); i++ {
+			info.Lockers = append(info.Lockers, Locker{
+				Client: clientList[i],
+				Cookie: cookieList[i],
+				Addr:   addrList[i],
+			})
+		}
+
+		return info, nil
+	}
+}
+
+// Take an exclusive advisory lock on the image, identified by cookie
+func (image *Image) LockExclusive(cookie string) error {
+	c_cookie := C.CString(cookie)
+	defer C.free(unsafe.Pointer(c_cookie))
+
+	if result := C.rbd_lock_exclusive(image.handle, c_cookie); result < 0 {
+		return fmt.Errorf("Unable to lock image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Take a shared advisory lock on the image, identified by cookie. Only
+// lockers using the same tag may share the lock
+func (image *Image) LockShared(cookie string, tag string) error {
+	c_cookie := C.CString(cookie)
+	defer C.free(unsafe.Pointer(c_cookie))
+	c_tag := C.CString(tag)
+	defer C.free(unsafe.Pointer(c_tag))
+
+	if result := C.rbd_lock_shared(image.handle, c_cookie, c_tag); result < 0 {
+		return fmt.Errorf("Unable to lock image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Release an advisory lock taken by this client with cookie
+func (image *Image) Unlock(cookie string) error {
+	c_cookie := C.CString(cookie)
+	defer C.free(unsafe.Pointer(c_cookie))
+
+	if result := C.rbd_unlock(image.handle, c_cookie); result < 0 {
+		return fmt.Errorf("Unable to unlock image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Release an advisory lock held by another client
+func (image *Image) BreakLock(client string, cookie string) error {
+	c_client := C.CString(client)
+	defer C.free(unsafe.Pointer(c_client))
+	c_cookie := C.CString(cookie)
+	defer C.free(unsafe.Pointer(c_cookie))
+
+	if result := C.rbd_break_lock(image.handle, c_client, c_cookie); result < 0 {
+		return fmt.Errorf("Unable to break lock of client '%s' on image '%s'", client, image.name)
+	}
+
+	return nil
+}
+
+// Break an advisory lock, first blocklisting the holder's client address so
+// that a holder which is still running cannot go on writing to the image
+// after failover. pool may be any pool in the image's cluster. An expire of
+// zero uses the cluster's default blocklist duration
+func (image *Image) BreakLockAndBlocklist(pool IOContext, client string, cookie string, expire time.Duration) error {
+	info, err := image.ListLockers()
+	if err != nil {
+		return err
+	}
+
+	var addr string
+	for _, locker := range info.Lockers {
+		if locker.Client == client && locker.Cookie == cookie {
+			addr = locker.Addr
+			break
+		}
+	}
+
+	if addr == "" {
+		return fmt.Errorf("No lock held by client '%s' with cookie '%s' on image '%s'", client, cookie, image.name)
+	}
+
+	if err := blocklistAddr(pool, addr, expire); err != nil {
+		return err
+	}
+
+	return image.BreakLock(client, cookie)
+}
+
+// Add a client address to the OSD blocklist
+func blocklistAddr(pool IOContext, addr string, expire time.Duration) error {
+	cluster := C.rados_ioctx_get_cluster(C.rados_ioctx_t(pool.Handle()))
+
+	result := monCommand(cluster, "osd blocklist", "blocklistop", addr, expire)
+
+	// Clusters older than Pacific only know the old command name
+	if result == -C.EINVAL {
+		result = monCommand(cluster, "osd blacklist", "blacklistop", addr, expire)
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to blocklist client address '%s'", addr)
+	}
+
+	return nil
+}
+
+func monCommand(cluster C.rados_t, prefix string, opKey string, addr string, expire time.Duration) C.int {
+	command := map[string]interface{}{
+		"prefix": prefix,
+		opKey:    "add",
+		"addr":   addr,
+	}
+	if expire > 0 {
+		command["expire"] = expire.Seconds()
+	}
+
+	encoded, err := json.Marshal(command)
+	if err != nil {
+		return -C.EINVAL
+	}
+
+	c_command := C.CString(string(encoded))
+	defer C.free(unsafe.Pointer(c_command))
+	commands := []*C.char{c_command}
+
+	var outbuf, outs *C.char
+	var outbufLen, outsLen C.size_t
+
+	result := C.rados_mon_command(cluster, &commands[0], 1, nil, 0, &outbuf, &outbufLen, &outs, &outsLen)
+
+	if outbuf != nil {
+		C.rados_buffer_free(outbuf)
+	}
+	if outs != nil {
+		C.rados_buffer_free(outs)
+	}
+
+	return result
+}
+
+////
+//   Managed locks (the exclusive-lock feature)
+////
+
+type LockMode int
+
+const (
+	RBD_LOCK_MODE_EXCLUSIVE LockMode = C.RBD_LOCK_MODE_EXCLUSIVE
+	RBD_LOCK_MODE_SHARED    LockMode = C.RBD_LOCK_MODE_SHARED
+)
+
+// Explicitly acquire the managed lock, rather than relying on librbd to take
+// it on the first write. Only RBD_LOCK_MODE_EXCLUSIVE is currently supported
+// by librbd
+func (image *Image) LockAcquire(mode LockMode) error {
+	if result := C.rbd_lock_acquire(image.handle, C.rbd_lock_mode_t(mode)); result < 0 {
+		return fmt.Errorf("Unable to acquire lock on image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Release a managed lock previously taken with LockAcquire()
+func (image *Image) LockRelease() error {
+	if result := C.rbd_lock_release(image.handle); result < 0 {
+		return fmt.Errorf("Unable to release lock on image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Returns the mode of the managed lock and the clients that own it
+func (image *Image) LockGetOwners() (LockMode, []string, error) {
+	var mode C.rbd_lock_mode_t
+	max := C.size_t(4)
+
+	for {
+		owners := make([]*C.char, max)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := C.rbd_lock_get_owners(image.handle, &mode, &owners[0], &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result == -C.ENOENT {
+			return 0, []string{}, nil
+		}
+		if result < 0 {
+			return 0, nil, fmt.Errorf("Unable to get lock owners of image '%s'", image.name)
+		}
+
+		list := make([]string, 0, int(max))
+		for _, owner := range owners[:max] {
+			list = append(list, C.GoString(owner))
+		}
+
+		C.rbd_lock_get_owners_cleanup(&owners[0], max)
+
+		return LockMode(mode), list, nil
+	}
+}
+
+// Reports whether this handle currently owns the exclusive lock
+func (image *Image) IsExclusiveLockOwner() (bool, error) {
+	var owner C.int
+
+	if result := C.rbd_is_exclusive_lock_owner(image.handle, &owner); result < 0 {
+		return false, fmt.Errorf("Unable to check exclusive lock ownership of image '%s'", image.name)
+	}
+
+	return owner != 0, nil
+}
+
+// Forcibly take the managed lock away from its current owner
+func (image *Image) LockBreak(mode LockMode, owner string) error {
+	c_owner := C.CString(owner)
+	defer C.free(unsafe.Pointer(c_owner))
+
+	if result := C.rbd_lock_break(image.handle, C.rbd_lock_mode_t(mode), c_owner); result < 0 {
+		return fmt.Errorf("Unable to break lock of '%s' on image '%s'", owner, image.name)
+	}
+
+	return nil
+}
+
+////
+//   Fencing
+////
+
+// Steps of FenceAndAcquire(), as reported in a FenceError
+const (
+	FENCE_STEP_LIST_LOCKERS = "list lockers"
+	FENCE_STEP_BLOCKLIST    = "blocklist"
+	FENCE_STEP_BREAK_LOCK   = "break lock"
+	FENCE_STEP_LOCK         = "lock"
+)
+
+// Returned by FenceAndAcquire(), identifying the step that failed
+type FenceError struct {
+	Step   string
+	Image  string
+	Locker *Locker
+	Err    error
+}
+
+func (err *FenceError) Error() string {
+	if err.Locker != nil {
+		return fmt.Sprintf("Unable to fence image '%s': %s of client '%s' failed: %v", err.Image, err.Step, err.Locker.Client, err.Err)
+	}
+
+	return fmt.Sprintf("Unable to fence image '%s': %s failed: %v", err.Image, err.Step, err.Err)
+}
+
+func (err *FenceError) Unwrap() error {
+	return err.Err
+}
+
+// Take an exclusive advisory lock on the image with cookie, taking it away
+// from any other holder first. This is the usual attach pattern for CSI
+// drivers: a previous holder is assumed to be stale, so if blocklist is set
+// its address is blocklisted before its lock is broken, ensuring it cannot
+// write to the image once we own it. pool must belong to the same cluster
+// connection the image was opened with, as it identifies this client. Holding
+// the lock already with the same cookie is not an error; locks this client
+// holds under other cookies are broken but never blocklisted
+func FenceAndAcquire(pool IOContext, image *Image, cookie string, blocklist bool) error {
+	info, err := image.ListLockers()
+	if err != nil {
+		return &FenceError{Step: FENCE_STEP_LIST_LOCKERS, Image: image.name, Err: err}
+	}
+
+	cluster := C.rados_ioctx_get_cluster(C.rados_ioctx_t(pool.Handle()))
+	self := fmt.Sprintf("client.%d", uint64(C.rados_get_instance_id(cluster)))
+
+	for i := range info.Lockers {
+		locker := &info.Lockers[i]
+		if locker.Client == self && locker.Cookie == cookie {
+			continue
+		}
+
+		if blocklist && locker.Client != self {
+			if err := blocklistAddr(pool, locker.Addr, 0); err != nil {
+				return &FenceError{Step: FENCE_STEP_BLOCKLIST, Image: image.name, Locker: locker, Err: err}
+			}
+		}
+
+		if err := image.BreakLock(locker.Client, locker.Cookie); err != nil {
+			return &FenceError{Step: FENCE_STEP_BREAK_LOCK, Image: image.name, Locker: locker, Err: err}
+		}
+	}
+
+	c_cookie := C.CString(cookie)
+	defer C.free(unsafe.Pointer(c_cookie))
+
+	result := C.rbd_lock_exclusive(image.handle, c_cookie)
+	if result < 0 && result != -C.EEXIST {
+		return &FenceError{Step: FENCE_STEP_LOCK, Image: image.name, Err: fmt.Errorf("Unable to lock image '%s'", image.name)}
+	}
+
+	return nil
+}
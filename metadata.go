@@ -0,0 +1,186 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Image metadata is a set of arbitrary key/value pairs stored with the image.
+// Keys prefixed with "conf_" override librbd client configuration for the
+// image
+
+func (image *Image) GetMetadata(key string) (string, error) {
+	c_key := C.CString(key)
+	defer C.free(unsafe.Pointer(c_key))
+	size := C.size_t(256)
+
+	for {
+		buf := make([]C.char, size)
+
+		// On -ERANGE, size is updated to the length required
+		result := C.rbd_metadata_get(image.handle, c_key, &buf[0], &size)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return "", fmt.Errorf("Unable to get metadata '%s' of image '%s'", key, image.name)
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
+func (image *Image) SetMetadata(key string, value string) error {
+	c_key := C.CString(key)
+	defer C.free(unsafe.Pointer(c_key))
+	c_value := C.CString(value)
+	defer C.free(unsafe.Pointer(c_value))
+
+	if result := C.rbd_metadata_set(image.handle, c_key, c_value); result < 0 {
+		return fmt.Errorf("Unable to set metadata '%s' of image '%s'", key, image.name)
+	}
+
+	return nil
+}
+
+func (image *Image) RemoveMetadata(key string) error {
+	c_key := C.CString(key)
+	defer C.free(unsafe.Pointer(c_key))
+
+	if result := C.rbd_metadata_remove(image.handle, c_key); result < 0 {
+		return fmt.Errorf("Unable to remove metadata '%s' of image '%s'", key, image.name)
+	}
+
+	return nil
+}
+
+func (image *Image) ListMetadata() (map[string]string, error) {
+	c_start := C.CString("")
+	defer C.free(unsafe.Pointer(c_start))
+	keysSize := C.size_t(1024)
+	valuesSize := C.size_t(1024)
+
+	for {
+		keys := make([]C.char, keysSize)
+		values := make([]C.char, valuesSize)
+
+		// On -ERANGE, both sizes are updated to the lengths required. A
+		// maximum of 0 returns every entry
+		result := C.rbd_metadata_list(image.handle, c_start, 0, &keys[0], &keysSize, &values[0], &valuesSize)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list metadata of image '%s'", image.name)
+		}
+
+		return zipStringLists(keys[:keysSize], values[:valuesSize]), nil
+	}
+}
+
+// Pool metadata works the same way. Keys prefixed with "conf_" there override
+// librbd client configuration for every image in the pool
+
+func PoolGetMetadata(pool IOContext, key string) (string, error) {
+	c_key := C.CString(key)
+	defer C.free(unsafe.Pointer(c_key))
+	size := C.size_t(256)
+
+	for {
+		buf := make([]C.char, size)
+
+		// On -ERANGE, size is updated to the length required
+		result := C.rbd_pool_metadata_get(C.rados_ioctx_t(pool.Handle()), c_key, &buf[0], &size)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return "", fmt.Errorf("Unable to get pool metadata '%s'", key)
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
+func PoolSetMetadata(pool IOContext, key string, value string) error {
+	c_key := C.CString(key)
+	defer C.free(unsafe.Pointer(c_key))
+	c_value := C.CString(value)
+	defer C.free(unsafe.Pointer(c_value))
+
+	if result := C.rbd_pool_metadata_set(C.rados_ioctx_t(pool.Handle()), c_key, c_value); result < 0 {
+		return fmt.Errorf("Unable to set pool metadata '%s'", key)
+	}
+
+	return nil
+}
+
+func PoolRemoveMetadata(pool IOContext, key string) error {
+	c_key := C.CString(key)
+	defer C.free(unsafe.Pointer(c_key))
+
+	if result := C.rbd_pool_metadata_remove(C.rados_ioctx_t(pool.Handle()), c_key); result < 0 {
+		return fmt.Errorf("Unable to remove pool metadata '%s'", key)
+	}
+
+	return nil
+}
+
+func PoolListMetadata(pool IOContext) (map[string]string, error) {
+	c_start := C.CString("")
+	defer C.free(unsafe.Pointer(c_start))
+	keysSize := C.size_t(1024)
+	valuesSize := C.size_t(1024)
+
+	for {
+		keys := make([]C.char, keysSize)
+		values := make([]C.char, valuesSize)
+
+		// On -ERANGE, both sizes are updated to the lengths required. A
+		// maximum of 0 returns every entry
+		result := C.rbd_pool_metadata_list(C.rados_ioctx_t(pool.Handle()), c_start, 0, &keys[0], &keysSize, &values[0], &valuesSize)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list pool metadata")
+		}
+
+		return zipStringLists(keys[:keysSize], values[:valuesSize]), nil
+	}
+}
+
+// Pair up two buffers of nul-terminated strings, as returned by the librbd
+// key/value listing calls
+func zipStringLists(keys []C.char, values []C.char) map[string]string {
+	keyList := splitStringList(keys)
+	valueList := splitStringList(values)
+	metadata := make(map[string]string, len(keyList))
+
+	for i, key := range keyList {
+		if i < len(valueList) {
+			metadata[key] = valueList[i]
+		}
+	}
+
+	return metadata
+}
+
+func splitStringList(buf []C.char) []string {
+	list := make([]string, 0)
+	start := 0
+
+	for x := range buf {
+		if buf[x] == 0x0 {
+			list = append(list, C.GoStringN(&buf[start], C.int(x-start)))
+			start = x + 1
+		}
+	}
+
+	return list
+}
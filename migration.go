@@ -0,0 +1,320 @@
+package gorbd
+
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+//
+// extern int goProgressCallback(uint64_t, uint64_t, void*);
+//
+// static inline int _rbd_migration_execute_with_progress(rados_ioctx_t io, const char *name, uintptr_t arg) {
+// 	return rbd_migration_execute_with_progress(io, name, goProgressCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_migration_commit_with_progress(rados_ioctx_t io, const char *name, uintptr_t arg) {
+// 	return rbd_migration_commit_with_progress(io, name, goProgressCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_migration_abort_with_progress(rados_ioctx_t io, const char *name, uintptr_t arg) {
+// 	return rbd_migration_abort_with_progress(io, name, goProgressCallback, (void*)arg);
+// }
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+////
+//   Live migration
+////
+//
+// A migration is driven in three steps: MigrationPrepare links a new
+// destination image to the source (clients must reopen by the destination
+// name), MigrationExecute copies the data across while client I/O continues,
+// and MigrationCommit removes the source. MigrationAbort may be called at any
+// point before the commit to roll back to the source image.
+
+// Prepare migrating the image to destImage in destPool, which may be the same
+// pool. opts describes the destination's layout and may be nil to keep the
+// source's
+func MigrationPrepare(pool IOContext, imageName string, destPool IOContext, destImage string, opts *ImageOptions) error {
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+	c_destImage := C.CString(destImage)
+	defer C.free(unsafe.Pointer(c_destImage))
+
+	if opts == nil {
+		opts = NewImageOptions()
+		defer opts.Destroy()
+	}
+
+	if result := C.rbd_migration_prepare(C.rados_ioctx_t(pool.Handle()), c_imageName,
+		C.rados_ioctx_t(destPool.Handle()), c_destImage, opts.handle); result < 0 {
+		return fmt.Errorf("Unable to prepare migration of image '%s' to '%s'", imageName, destImage)
+	}
+
+	return nil
+}
+
+// Copy the data of a prepared migration. imageName may name either the source
+// or the destination. progress may be nil
+func MigrationExecute(pool IOContext, imageName string, progress ProgressFunc) error {
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	var result C.int
+
+	if progress == nil {
+		result = C.rbd_migration_execute(C.rados_ioctx_t(pool.Handle()), c_imageName)
+	} else {
+		id := registerCallback(progress)
+		defer unregisterCallback(id)
+
+		result = C._rbd_migration_execute_with_progress(C.rados_ioctx_t(pool.Handle()), c_imageName, C.uintptr_t(id))
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to execute migration of image '%s'", imageName)
+	}
+
+	return nil
+}
+
+// Finish an executed migration, removing the source image. progress may be
+// nil
+func MigrationCommit(pool IOContext, imageName string, progress ProgressFunc) error {
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	var result C.int
+
+	if progress == nil {
+		result = C.rbd_migration_commit(C.rados_ioctx_t(pool.Handle()), c_imageName)
+	} else {
+		id := registerCallback(progress)
+		defer unregisterCallback(id)
+
+		result = C._rbd_migration_commit_with_progress(C.rados_ioctx_t(pool.Handle()), c_imageName, C.uintptr_t(id))
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to commit migration of image '%s'", imageName)
+	}
+
+	return nil
+}
+
+// Cancel a migration that has not been committed, removing the destination
+// image. progress may be nil
+func MigrationAbort(pool IOContext, imageName string, progress ProgressFunc) error {
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	var result C.int
+
+	if progress == nil {
+		result = C.rbd_migration_abort(C.rados_ioctx_t(pool.Handle()), c_imageName)
+	} else {
+		id := registerCallback(progress)
+		defer unregisterCallback(id)
+
+		result = C._rbd_migration_abort_with_progress(C.rados_ioctx_t(pool.Handle()), c_imageName, C.uintptr_t(id))
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to abort migration of image '%s'", imageName)
+	}
+
+	return nil
+}
+
+type MigrationState int
+
+const (
+	RBD_IMAGE_MIGRATION_STATE_UNKNOWN    MigrationState = C.RBD_IMAGE_MIGRATION_STATE_UNKNOWN
+	RBD_IMAGE_MIGRATION_STATE_ERROR      MigrationState = C.RBD_IMAGE_MIGRATION_STATE_ERROR
+	RBD_IMAGE_MIGRATION_STATE_PREPARING  MigrationState = C.RBD_IMAGE_MIGRATION_STATE_PREPARING
+	RBD_IMAGE_MIGRATION_STATE_PREPARED   MigrationState = C.RBD_IMAGE_MIGRATION_STATE_PREPARED
+	RBD_IMAGE_MIGRATION_STATE_EXECUTING  MigrationState = C.RBD_IMAGE_MIGRATION_STATE_EXECUTING
+	RBD_IMAGE_MIGRATION_STATE_EXECUTED   MigrationState = C.RBD_IMAGE_MIGRATION_STATE_EXECUTED
+	RBD_IMAGE_MIGRATION_STATE_COMMITTING MigrationState = C.RBD_IMAGE_MIGRATION_STATE_COMMITTING
+	RBD_IMAGE_MIGRATION_STATE_ABORTING   MigrationState = C.RBD_IMAGE_MIGRATION_STATE_ABORTING
+)
+
+type ImageMigrationStatus struct {
+	SourcePoolID        int64
+	SourcePoolNamespace string
+	SourceImageName     string
+	SourceImageID       string
+
+	DestPoolID        int64
+	DestPoolNamespace string
+	DestImageName     string
+	DestImageID       string
+
+	State MigrationState
+
+	// Human readable detail for the state, e.g. the reason for
+	// RBD_IMAGE_MIGRATION_STATE_ERROR
+	StateDescription string
+}
+
+// Returns the status of the migration involving the image. imageName may name
+// either the source or the destination
+func MigrationStatus(pool IOContext, imageName string) (*ImageMigrationStatus, error) {
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+	var status C.rbd_image_migration_status_t
+
+	if result := C.rbd_migration_status(C.rados_ioctx_t(pool.Handle()), c_imageName,
+		&status, C.sizeof_rbd_image_migration_status_t); result < 0 {
+		return nil, fmt.Errorf("Unable to get migration status of image '%s'", imageName)
+	}
+	defer C.rbd_migration_status_cleanup(&status)
+
+	return &ImageMigrationStatus{
+		SourcePoolID:        int64(status.source_pool_id),
+		SourcePoolNamespace: C.GoString(status.source_pool_namespace),
+		SourceImageName:     C.GoString(status.source_image_name),
+		SourceImageID:       C.GoString(status.source_image_id),
+		DestPoolID:          int64(status.dest_pool_id),
+		DestPoolNamespace:   C.GoString(status.dest_pool_namespace),
+		DestImageName:       C.GoString(status.dest_image_name),
+		DestImageID:         C.GoString(status.dest_image_id),
+		State:               MigrationState(status.state),
+		StateDescription:    C.GoString(status.state_description),
+	}, nil
+}
+
+////
+//   Import from an external source
+////
+
+// Source image formats understood by MigrationPrepareImport()
+const (
+	MIGRATION_SOURCE_NATIVE = "native"
+	MIGRATION_SOURCE_RAW    = "raw"
+	MIGRATION_SOURCE_QCOW   = "qcow"
+)
+
+// Streams the raw and qcow formats can be read from
+const (
+	MIGRATION_STREAM_FILE = "file"
+	MIGRATION_STREAM_HTTP = "http"
+	MIGRATION_STREAM_S3   = "s3"
+)
+
+// Where a raw or qcow source is read from. FilePath is used by file streams,
+// URL by http and s3 streams, and the keys by s3 streams only
+type MigrationStream struct {
+	Type      string `json:"type"`
+	FilePath  string `json:"file_path,omitempty"`
+	URL       string `json:"url,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+}
+
+// A snapshot to recreate on the destination of a raw import, from the state
+// of the image held in Stream
+type MigrationSnapshotSpec struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Stream MigrationStream `json:"stream"`
+}
+
+// The source-spec accepted by librbd's migration engine. Build one with
+// NativeMigrationSource(), RawMigrationSource() or QcowMigrationSource()
+type MigrationSourceSpec struct {
+	Type string `json:"type"`
+
+	// Native sources
+	PoolName      string `json:"pool_name,omitempty"`
+	PoolNamespace string `json:"pool_namespace,omitempty"`
+	ImageName     string `json:"image_name,omitempty"`
+	ImageID       string `json:"image_id,omitempty"`
+	SnapName      string `json:"snap_name,omitempty"`
+
+	// Raw and qcow sources
+	Stream *MigrationStream `json:"stream,omitempty"`
+
+	// Raw sources only, oldest first
+	Snapshots []MigrationSnapshotSpec `json:"snapshots,omitempty"`
+}
+
+// An RBD image in another pool (or namespace) of the same cluster. snapName
+// may be empty to import the image head
+func NativeMigrationSource(poolName string, imageName string, snapName string) *MigrationSourceSpec {
+	return &MigrationSourceSpec{
+		Type:      MIGRATION_SOURCE_NATIVE,
+		PoolName:  poolName,
+		ImageName: imageName,
+		SnapName:  snapName,
+	}
+}
+
+func RawMigrationSource(stream MigrationStream) *MigrationSourceSpec {
+	return &MigrationSourceSpec{Type: MIGRATION_SOURCE_RAW, Stream: &stream}
+}
+
+func QcowMigrationSource(stream MigrationStream) *MigrationSourceSpec {
+	return &MigrationSourceSpec{Type: MIGRATION_SOURCE_QCOW, Stream: &stream}
+}
+
+// Add a snapshot to a raw source. Snapshots must be added oldest first
+func (spec *MigrationSourceSpec) AddSnapshot(name string, stream MigrationStream) *MigrationSourceSpec {
+	spec.Snapshots = append(spec.Snapshots, MigrationSnapshotSpec{
+		Type:   MIGRATION_SOURCE_RAW,
+		Name:   name,
+		Stream: stream,
+	})
+
+	return spec
+}
+
+func FileStream(path string) MigrationStream {
+	return MigrationStream{Type: MIGRATION_STREAM_FILE, FilePath: path}
+}
+
+func HTTPStream(url string) MigrationStream {
+	return MigrationStream{Type: MIGRATION_STREAM_HTTP, URL: url}
+}
+
+func S3Stream(url string, accessKey string, secretKey string) MigrationStream {
+	return MigrationStream{Type: MIGRATION_STREAM_S3, URL: url, AccessKey: accessKey, SecretKey: secretKey}
+}
+
+// Returns the spec encoded as JSON, as passed to librbd
+func (spec *MigrationSourceSpec) JSON() (string, error) {
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+// Prepare importing an external source into destImage in destPool. The
+// migration is then driven with MigrationExecute() and MigrationCommit() on
+// the destination, as for any other migration. opts may be nil
+func MigrationPrepareImport(source *MigrationSourceSpec, destPool IOContext, destImage string, opts *ImageOptions) error {
+	encoded, err := source.JSON()
+	if err != nil {
+		return err
+	}
+
+	c_source := C.CString(encoded)
+	defer C.free(unsafe.Pointer(c_source))
+	c_destImage := C.CString(destImage)
+	defer C.free(unsafe.Pointer(c_destImage))
+
+	if opts == nil {
+		opts = NewImageOptions()
+		defer opts.Destroy()
+	}
+
+	if result := C.rbd_migration_prepare_import(c_source, C.rados_ioctx_t(destPool.Handle()), c_destImage, opts.handle); result < 0 {
+		return fmt.Errorf("Unable to prepare import of %s source to image '%s'", source.Type, destImage)
+	}
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+type MirrorMode int
+
+const (
+	// Mirroring is disabled for the pool
+	RBD_MIRROR_MODE_DISABLED MirrorMode = C.RBD_MIRROR_MODE_DISABLED
+
+	// Only images with mirroring explicitly enabled are mirrored
+	RBD_MIRROR_MODE_IMAGE MirrorMode = C.RBD_MIRROR_MODE_IMAGE
+
+	// Every image with the journaling feature is mirrored
+	RBD_MIRROR_MODE_POOL MirrorMode = C.RBD_MIRROR_MODE_POOL
+)
+
+func MirrorModeGet(pool IOContext) (MirrorMode, error) {
+	var mode C.rbd_mirror_mode_t
+
+	if result := C.rbd_mirror_mode_get(C.rados_ioctx_t(pool.Handle()), &mode); result < 0 {
+		return RBD_MIRROR_MODE_DISABLED, errors.New("Failed to get pool mirror mode")
+	}
+
+	return MirrorMode(mode), nil
+}
+
+// Set the pool's mirror mode. Disabling mirroring fails while any image in
+// the pool still has it enabled
+func MirrorModeSet(pool IOContext, mode MirrorMode) error {
+	if result := C.rbd_mirror_mode_set(C.rados_ioctx_t(pool.Handle()), C.rbd_mirror_mode_t(mode)); result < 0 {
+		return errors.New("Failed to set pool mirror mode")
+	}
+
+	return nil
+}
+
+////
+//   Peer bootstrap
+////
+
+type MirrorPeerDirection int
+
+const (
+	RBD_MIRROR_PEER_DIRECTION_RX    MirrorPeerDirection = C.RBD_MIRROR_PEER_DIRECTION_RX
+	RBD_MIRROR_PEER_DIRECTION_TX    MirrorPeerDirection = C.RBD_MIRROR_PEER_DIRECTION_TX
+	RBD_MIRROR_PEER_DIRECTION_RX_TX MirrorPeerDirection = C.RBD_MIRROR_PEER_DIRECTION_RX_TX
+)
+
+// Create a bootstrap token for the pool, to be passed to
+// MirrorPeerBootstrapImport() on the peer cluster. The token carries
+// credentials for a mirroring user and must be kept secret
+func MirrorPeerBootstrapCreate(pool IOContext) (string, error) {
+	size := C.size_t(512)
+
+	for {
+		buf := make([]C.char, size)
+
+		// On -ERANGE, size is updated to the length required
+		result := C.rbd_mirror_peer_bootstrap_create(C.rados_ioctx_t(pool.Handle()), &buf[0], &size)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return "", errors.New("Failed to create mirror peer bootstrap token")
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
+// Import a token created by MirrorPeerBootstrapCreate() on the peer cluster,
+// registering that cluster as a mirroring peer of the pool
+func MirrorPeerBootstrapImport(pool IOContext, direction MirrorPeerDirection, token string) error {
+	c_token := C.CString(token)
+	defer C.free(unsafe.Pointer(c_token))
+
+	if result := C.rbd_mirror_peer_bootstrap_import(C.rados_ioctx_t(pool.Handle()),
+		C.rbd_mirror_peer_direction_t(direction), c_token); result < 0 {
+		return errors.New("Failed to import mirror peer bootstrap token")
+	}
+
+	return nil
+}
+
+////
+//   Image mirroring
+////
+
+type ImageMirrorMode int
+
+const (
+	// Writes are replayed from the image's journal; requires the journaling
+	// feature
+	RBD_MIRROR_IMAGE_MODE_JOURNAL ImageMirrorMode = C.RBD_MIRROR_IMAGE_MODE_JOURNAL
+
+	// Periodic mirror snapshots are replicated
+	RBD_MIRROR_IMAGE_MODE_SNAPSHOT ImageMirrorMode = C.RBD_MIRROR_IMAGE_MODE_SNAPSHOT
+)
+
+// Enable mirroring of the image. The pool must be in RBD_MIRROR_MODE_IMAGE
+func (image *Image) MirrorEnable(mode ImageMirrorMode) error {
+	if result := C.rbd_mirror_image_enable2(image.handle, C.rbd_mirror_image_mode_t(mode)); result < 0 {
+		return fmt.Errorf("Unable to enable mirroring of image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Disable mirroring of the image. force allows disabling it on a
+// non-primary image
+func (image *Image) MirrorDisable(force bool) error {
+	if result := C.rbd_mirror_image_disable(image.handle, C.bool(force)); result < 0 {
+		return fmt.Errorf("Unable to disable mirroring of image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Make the image primary, so it accepts writes. force is required when the
+// peer's copy has not been demoted, e.g. when failing over after losing the
+// peer cluster
+func (image *Image) MirrorPromote(force bool) error {
+	if result := C.rbd_mirror_image_promote(image.handle, C.bool(force)); result < 0 {
+		return fmt.Errorf("Unable to promote image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Make the image non-primary, so the peer's copy can be promoted
+func (image *Image) MirrorDemote() error {
+	if result := C.rbd_mirror_image_demote(image.handle); result < 0 {
+		return fmt.Errorf("Unable to demote image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Flag a non-primary image to be resynchronised in full from the primary,
+// e.g. after a split-brain
+func (image *Image) MirrorResync() error {
+	if result := C.rbd_mirror_image_resync(image.handle); result < 0 {
+		return fmt.Errorf("Unable to resync image '%s'", image.name)
+	}
+
+	return nil
+}
+
+type MirrorImageState int
+
+const (
+	RBD_MIRROR_IMAGE_DISABLING MirrorImageState = C.RBD_MIRROR_IMAGE_DISABLING
+	RBD_MIRROR_IMAGE_ENABLED   MirrorImageState = C.RBD_MIRROR_IMAGE_ENABLED
+	RBD_MIRROR_IMAGE_DISABLED  MirrorImageState = C.RBD_MIRROR_IMAGE_DISABLED
+)
+
+type MirrorImageInfo struct {
+	// Identifies the image across all peer clusters
+	GlobalID string
+	State    MirrorImageState
+	Primary  bool
+}
+
+func (image *Image) MirrorImageInfo() (*MirrorImageInfo, error) {
+	var info C.rbd_mirror_image_info_t
+
+	if result := C.rbd_mirror_image_get_info(image.handle, &info, C.sizeof_rbd_mirror_image_info_t); result < 0 {
+		return nil, fmt.Errorf("Unable to get mirroring info of image '%s'", image.name)
+	}
+	defer C.rbd_mirror_image_get_info_cleanup(&info)
+
+	return &MirrorImageInfo{
+		GlobalID: C.GoString(info.global_id),
+		State:    MirrorImageState(info.state),
+		Primary:  bool(info.primary),
+	}, nil
+}
+
+func (image *Image) MirrorImageMode() (ImageMirrorMode, error) {
+	var mode C.rbd_mirror_image_mode_t
+
+	if result := C.rbd_mirror_image_get_mode(image.handle, &mode); result < 0 {
+		return RBD_MIRROR_IMAGE_MODE_JOURNAL, fmt.Errorf("Unable to get mirroring mode of image '%s'", image.name)
+	}
+
+	return ImageMirrorMode(mode), nil
+}
+
+////
+//   Mirroring status
+////
+
+type MirrorImageStatusState int
+
+const (
+	MIRROR_IMAGE_STATUS_STATE_UNKNOWN         MirrorImageStatusState = C.MIRROR_IMAGE_STATUS_STATE_UNKNOWN
+	MIRROR_IMAGE_STATUS_STATE_ERROR           MirrorImageStatusState = C.MIRROR_IMAGE_STATUS_STATE_ERROR
+	MIRROR_IMAGE_STATUS_STATE_SYNCING         MirrorImageStatusState = C.MIRROR_IMAGE_STATUS_STATE_SYNCING
+	MIRROR_IMAGE_STATUS_STATE_STARTING_REPLAY MirrorImageStatusState = C.MIRROR_IMAGE_STATUS_STATE_STARTING_REPLAY
+	MIRROR_IMAGE_STATUS_STATE_REPLAYING       MirrorImageStatusState = C.MIRROR_IMAGE_STATUS_STATE_REPLAYING
+	MIRROR_IMAGE_STATUS_STATE_STOPPING_REPLAY MirrorImageStatusState = C.MIRROR_IMAGE_STATUS_STATE_STOPPING_REPLAY
+	MIRROR_IMAGE_STATUS_STATE_STOPPED         MirrorImageStatusState = C.MIRROR_IMAGE_STATUS_STATE_STOPPED
+)
+
+// The mirroring status of an image as reported by the rbd-mirror daemon of
+// one site
+type MirrorImageSiteStatus struct {
+	// Empty for the local site
+	MirrorUUID string
+	State      MirrorImageStatusState
+
+	// Free-form detail from rbd-mirror. While replaying this includes the
+	// replay position, from which lag can be derived
+	Description string
+	LastUpdate  time.Time
+
+	// Whether an rbd-mirror daemon is running for the site
+	Up bool
+}
+
+type MirrorImageGlobalStatus struct {
+	Name         string
+	Info         MirrorImageInfo
+	SiteStatuses []MirrorImageSiteStatus
+}
+
+// Returns the status reported for the local site, if any
+func (status *MirrorImageGlobalStatus) LocalStatus() (*MirrorImageSiteStatus, bool) {
+	for i := range status.SiteStatuses {
+		if status.SiteStatuses[i].MirrorUUID == "" {
+			return &status.SiteStatuses[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func newMirrorImageGlobalStatus(status *C.rbd_mirror_image_global_status_t) MirrorImageGlobalStatus {
+	count := int(status.site_statuses_count)
+	sites := make([]MirrorImageSiteStatus, 0, count)
+
+	if count > 0 {
+		for _, site := range unsafe.Slice(status.site_statuses, count) {
+			sites = append(sites, MirrorImageSiteStatus{
+				MirrorUUID:  C.GoString(site.mirror_uuid),
+				State:       MirrorImageStatusState(site.state),
+				Description: C.GoString(site.description),
+				LastUpdate:  time.Unix(int64(site.last_update), 0),
+				Up:          bool(site.up),
+			})
+		}
+	}
+
+	return MirrorImageGlobalStatus{
+		Name: C.GoString(status.name),
+		Info: MirrorImageInfo{
+			GlobalID: C.GoString(status.info.global_id),
+			State:    MirrorImageState(status.info.state),
+			Primary:  bool(status.info.primary),
+		},
+		SiteStatuses: sites,
+	}
+}
+
+func (image *Image) MirrorImageGlobalStatus() (*MirrorImageGlobalStatus, error) {
+	var status C.rbd_mirror_image_global_status_t
+
+	if result := C.rbd_mirror_image_get_global_status(image.handle, &status, C.sizeof_rbd_mirror_image_global_status_t); result < 0 {
+		return nil, fmt.Errorf("Unable to get mirroring status of image '%s'", image.name)
+	}
+	defer C.rbd_mirror_image_global_status_cleanup(&status)
+
+	global := newMirrorImageGlobalStatus(&status)
+
+	return &global, nil
+}
+
+// The status of one image in a pool listing, keyed by image ID
+type MirrorImageGlobalStatusEntry struct {
+	ID     string
+	Status MirrorImageGlobalStatus
+}
+
+// Returns up to max mirrored images of the pool, in image ID order, starting
+// after the image with ID startID. Pass an empty startID for the first page,
+// and the ID of the last entry returned for each following page; an empty
+// page marks the end of the listing
+func MirrorImageGlobalStatusList(pool IOContext, startID string, max int) ([]MirrorImageGlobalStatusEntry, error) {
+	c_startID := C.CString(startID)
+	defer C.free(unsafe.Pointer(c_startID))
+
+	if max <= 0 {
+		return []MirrorImageGlobalStatusEntry{}, nil
+	}
+
+	ids := make([]*C.char, max)
+	statuses := make([]C.rbd_mirror_image_global_status_t, max)
+	var count C.size_t
+
+	if result := C.rbd_mirror_image_global_status_list(C.rados_ioctx_t(pool.Handle()), c_startID, C.size_t(max),
+		&ids[0], &statuses[0], &count); result < 0 {
+		return nil, errors.New("Failed to list mirror image status")
+	}
+	defer C.rbd_mirror_image_global_status_list_cleanup(&ids[0], &statuses[0], count)
+
+	entries := make([]MirrorImageGlobalStatusEntry, 0, int(count))
+	for i := 0; i < int(count); i++ {
+		entries = append(entries, MirrorImageGlobalStatusEntry{
+			ID:     C.GoString(ids[i]),
+			Status: newMirrorImageGlobalStatus(&statuses[i]),
+		})
+	}
+
+	return entries, nil
+}
+
+// Returns the status of every mirrored image in the pool, fetching it from
+// librbd a page at a time
+func ListMirrorImageGlobalStatus(pool IOContext) ([]MirrorImageGlobalStatusEntry, error) {
+	all := make([]MirrorImageGlobalStatusEntry, 0)
+	startID := ""
+
+	for {
+		entries, err := MirrorImageGlobalStatusList(pool, startID, 256)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return all, nil
+		}
+
+		all = append(all, entries...)
+		startID = entries[len(entries)-1].ID
+	}
+}
@@ -0,0 +1,122 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Create an RBD namespace within the pool. Images in different namespaces are
+// isolated from each other, and clients may be restricted to one namespace
+func NamespaceCreate(pool IOContext, namespace string) error {
+	c_namespace := C.CString(namespace)
+	defer C.free(unsafe.Pointer(c_namespace))
+
+	if result := C.rbd_namespace_create(C.rados_ioctx_t(pool.Handle()), c_namespace); result < 0 {
+		return fmt.Errorf("Unable to create namespace '%s'", namespace)
+	}
+
+	return nil
+}
+
+// Remove an RBD namespace. The namespace must not contain any images,
+// including images in the trash
+func NamespaceRemove(pool IOContext, namespace string) error {
+	c_namespace := C.CString(namespace)
+	defer C.free(unsafe.Pointer(c_namespace))
+
+	if result := C.rbd_namespace_remove(C.rados_ioctx_t(pool.Handle()), c_namespace); result < 0 {
+		return fmt.Errorf("Unable to remove namespace '%s'", namespace)
+	}
+
+	return nil
+}
+
+func NamespaceList(pool IOContext) ([]string, error) {
+	size := C.size_t(1024)
+
+	for {
+		buf := make([]C.char, size)
+
+		// On -ERANGE, size is updated to the length required
+		result := C.rbd_namespace_list(C.rados_ioctx_t(pool.Handle()), &buf[0], &size)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list namespaces")
+		}
+
+		return splitStringList(buf[:size]), nil
+	}
+}
+
+func NamespaceExists(pool IOContext, namespace string) (bool, error) {
+	c_namespace := C.CString(namespace)
+	defer C.free(unsafe.Pointer(c_namespace))
+	var exists C.bool
+
+	if result := C.rbd_namespace_exists(C.rados_ioctx_t(pool.Handle()), c_namespace, &exists); result < 0 {
+		return false, fmt.Errorf("Unable to check for namespace '%s'", namespace)
+	}
+
+	return bool(exists), nil
+}
+
+// Anything bound to a pool's I/O context that pool-level functions can
+// operate on: a IOContext, or a *NamespacedPool
+type IOContext interface {
+	Handle() unsafe.Pointer
+}
+
+// A pool handle bound to an RBD namespace. It has its own I/O context, so the
+// pool handle it was created from is unaffected and both may be used
+// concurrently. Pass it wherever pool-level functions (ListImages,
+// CreateImage4, OpenImage, TrashList, ...) take an IOContext to have them
+// operate within the namespace
+type NamespacedPool struct {
+	ioctx     C.rados_ioctx_t
+	namespace string
+}
+
+// Returns a handle for the pool bound to namespace. An empty namespace
+// selects the pool's default namespace. The handle must be released with
+// Destroy()
+func OpenNamespace(pool IOContext, namespace string) (*NamespacedPool, error) {
+	parent := C.rados_ioctx_t(pool.Handle())
+	cluster := C.rados_ioctx_get_cluster(parent)
+
+	var ioctx C.rados_ioctx_t
+	if result := C.rados_ioctx_create2(cluster, C.rados_ioctx_get_id(parent), &ioctx); result < 0 {
+		return nil, fmt.Errorf("Unable to open namespace '%s'", namespace)
+	}
+
+	c_namespace := C.CString(namespace)
+	defer C.free(unsafe.Pointer(c_namespace))
+	C.rados_ioctx_set_namespace(ioctx, c_namespace)
+
+	return &NamespacedPool{ioctx: ioctx, namespace: namespace}, nil
+}
+
+func (pool *NamespacedPool) Handle() unsafe.Pointer {
+	return unsafe.Pointer(pool.ioctx)
+}
+
+func (pool *NamespacedPool) Namespace() string {
+	return pool.namespace
+}
+
+// Release the handle. Images opened through it remain usable
+func (pool *NamespacedPool) Destroy() {
+	C.rados_ioctx_destroy(pool.ioctx)
+}
+
+// Returns the RBD namespace the pool handle is bound to, or an empty string
+// for the default namespace
+func GetNamespace(pool IOContext) (string, error) {
+	return poolNamespace(pool)
+}
@@ -0,0 +1,136 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Image option identifiers, as used with ImageOptions
+const (
+	RBD_IMAGE_OPTION_FORMAT              = C.RBD_IMAGE_OPTION_FORMAT
+	RBD_IMAGE_OPTION_FEATURES            = C.RBD_IMAGE_OPTION_FEATURES
+	RBD_IMAGE_OPTION_ORDER               = C.RBD_IMAGE_OPTION_ORDER
+	RBD_IMAGE_OPTION_STRIPE_UNIT         = C.RBD_IMAGE_OPTION_STRIPE_UNIT
+	RBD_IMAGE_OPTION_STRIPE_COUNT        = C.RBD_IMAGE_OPTION_STRIPE_COUNT
+	RBD_IMAGE_OPTION_JOURNAL_ORDER       = C.RBD_IMAGE_OPTION_JOURNAL_ORDER
+	RBD_IMAGE_OPTION_JOURNAL_SPLAY_WIDTH = C.RBD_IMAGE_OPTION_JOURNAL_SPLAY_WIDTH
+	RBD_IMAGE_OPTION_JOURNAL_POOL        = C.RBD_IMAGE_OPTION_JOURNAL_POOL
+	RBD_IMAGE_OPTION_FEATURES_SET        = C.RBD_IMAGE_OPTION_FEATURES_SET
+	RBD_IMAGE_OPTION_FEATURES_CLEAR      = C.RBD_IMAGE_OPTION_FEATURES_CLEAR
+	RBD_IMAGE_OPTION_DATA_POOL           = C.RBD_IMAGE_OPTION_DATA_POOL
+	RBD_IMAGE_OPTION_FLATTEN             = C.RBD_IMAGE_OPTION_FLATTEN
+	RBD_IMAGE_OPTION_CLONE_FORMAT        = C.RBD_IMAGE_OPTION_CLONE_FORMAT
+	RBD_IMAGE_OPTION_MIRROR_IMAGE_MODE   = C.RBD_IMAGE_OPTION_MIRROR_IMAGE_MODE
+)
+
+// A set of image options, used when creating, cloning, copying or
+// migrating images. Must be released with Destroy() once no longer needed
+type ImageOptions struct {
+	handle C.rbd_image_options_t
+}
+
+func NewImageOptions() *ImageOptions {
+	opts := &ImageOptions{}
+	C.rbd_image_options_create(&opts.handle)
+
+	return opts
+}
+
+func (opts *ImageOptions) Destroy() {
+	C.rbd_image_options_destroy(opts.handle)
+}
+
+func (opts *ImageOptions) SetString(option int, value string) error {
+	c_value := C.CString(value)
+	defer C.free(unsafe.Pointer(c_value))
+
+	if result := C.rbd_image_options_set_string(opts.handle, C.int(option), c_value); result < 0 {
+		return fmt.Errorf("Unable to set image option %d", option)
+	}
+
+	return nil
+}
+
+func (opts *ImageOptions) GetString(option int) (string, error) {
+	size := 256
+
+	for {
+		buf := make([]C.char, size)
+
+		result := C.rbd_image_options_get_string(opts.handle, C.int(option), &buf[0], C.size_t(size))
+		if result == -C.ERANGE {
+			size *= 2
+			continue
+		}
+		if result < 0 {
+			return "", fmt.Errorf("Unable to get image option %d", option)
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
+func (opts *ImageOptions) SetUint64(option int, value uint64) error {
+	if result := C.rbd_image_options_set_uint64(opts.handle, C.int(option), C.uint64_t(value)); result < 0 {
+		return fmt.Errorf("Unable to set image option %d", option)
+	}
+
+	return nil
+}
+
+func (opts *ImageOptions) GetUint64(option int) (uint64, error) {
+	var value C.uint64_t
+
+	if result := C.rbd_image_options_get_uint64(opts.handle, C.int(option), &value); result < 0 {
+		return 0, fmt.Errorf("Unable to get image option %d", option)
+	}
+
+	return uint64(value), nil
+}
+
+func (opts *ImageOptions) IsSet(option int) (bool, error) {
+	var isSet C.bool
+
+	if result := C.rbd_image_options_is_set(opts.handle, C.int(option), &isSet); result < 0 {
+		return false, fmt.Errorf("Unable to query image option %d", option)
+	}
+
+	return bool(isSet), nil
+}
+
+func (opts *ImageOptions) Unset(option int) error {
+	if result := C.rbd_image_options_unset(opts.handle, C.int(option)); result < 0 {
+		return fmt.Errorf("Unable to unset image option %d", option)
+	}
+
+	return nil
+}
+
+func (opts *ImageOptions) Clear() {
+	C.rbd_image_options_clear(opts.handle)
+}
+
+func (opts *ImageOptions) IsEmpty() bool {
+	return C.rbd_image_options_is_empty(opts.handle) != 0
+}
+
+// Place the image's data objects in a separate pool (such as an erasure-coded
+// pool), leaving only metadata in the pool the image is created in
+func (opts *ImageOptions) SetDataPool(pool string) error {
+	return opts.SetString(RBD_IMAGE_OPTION_DATA_POOL, pool)
+}
+
+// Stripe the image's data across stripeCount objects in units of stripeUnit
+// bytes. The stripe unit must evenly divide the object size
+func (opts *ImageOptions) SetStriping(stripeUnit uint64, stripeCount uint64) error {
+	if err := opts.SetUint64(RBD_IMAGE_OPTION_STRIPE_UNIT, stripeUnit); err != nil {
+		return err
+	}
+
+	return opts.SetUint64(RBD_IMAGE_OPTION_STRIPE_COUNT, stripeCount)
+}
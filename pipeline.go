@@ -0,0 +1,46 @@
+package gorbd
+
+// An io.Writer which writes sequentially to an image from offset zero,
+// keeping up to queueDepth asynchronous writes in flight. Write() blocks once
+// the queue is full, and errors from earlier writes are reported by later
+// calls to Write() or by Close()
+type PipelinedWriter struct {
+	image  *Image
+	offset uint64
+	depth  int
+	group  *AioGroup
+}
+
+func NewPipelinedWriter(image *Image, queueDepth int) *PipelinedWriter {
+	if queueDepth < 1 {
+		queueDepth = 1
+	}
+
+	return &PipelinedWriter{
+		image: image,
+		depth: queueDepth,
+		group: NewAioGroup(),
+	}
+}
+
+func (writer *PipelinedWriter) Write(data []byte) (int, error) {
+	if err := writer.group.WaitN(writer.depth - 1); err != nil {
+		return 0, err
+	}
+
+	c, err := writer.image.AioWrite(writer.offset, data)
+	if err != nil {
+		return 0, err
+	}
+
+	writer.group.Add(c)
+	writer.offset += uint64(len(data))
+
+	return len(data), nil
+}
+
+// Wait for all outstanding writes to complete. The image itself is left
+// open
+func (writer *PipelinedWriter) Close() error {
+	return writer.group.Wait()
+}
@@ -0,0 +1,266 @@
+package gorbd
+
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// Image counts and provisioned sizes for a pool (or the namespace its handle
+// is bound to). Images in the trash are counted separately
+type PoolStats struct {
+	Images                   uint64
+	ImageProvisionedBytes    uint64
+	ImageMaxProvisionedBytes uint64
+	ImageSnapshots           uint64
+
+	TrashImages              uint64
+	TrashProvisionedBytes    uint64
+	TrashMaxProvisionedBytes uint64
+	TrashSnapshots           uint64
+}
+
+func GetPoolStats(pool IOContext) (*PoolStats, error) {
+	options := []C.int{
+		C.RBD_POOL_STAT_OPTION_IMAGES,
+		C.RBD_POOL_STAT_OPTION_IMAGE_PROVISIONED_BYTES,
+		C.RBD_POOL_STAT_OPTION_IMAGE_MAX_PROVISIONED_BYTES,
+		C.RBD_POOL_STAT_OPTION_IMAGE_SNAPSHOTS,
+		C.RBD_POOL_STAT_OPTION_TRASH_IMAGES,
+		C.RBD_POOL_STAT_OPTION_TRASH_PROVISIONED_BYTES,
+		C.RBD_POOL_STAT_OPTION_TRASH_MAX_PROVISIONED_BYTES,
+		C.RBD_POOL_STAT_OPTION_TRASH_SNAPSHOTS,
+	}
+
+	// librbd keeps the value pointers until rbd_pool_stats_get(), so they
+	// must live in C memory
+	c_values := (*C.uint64_t)(C.calloc(C.size_t(len(options)), C.sizeof_uint64_t))
+	defer C.free(unsafe.Pointer(c_values))
+	values := unsafe.Slice(c_values, len(options))
+
+	var stats C.rbd_pool_stats_t
+	C.rbd_pool_stats_create(&stats)
+	defer C.rbd_pool_stats_destroy(stats)
+
+	for i, option := range options {
+		if result := C.rbd_pool_stats_option_add_uint64(stats, option, &values[i]); result < 0 {
+			return nil, errors.New("Failed to set up pool stats request")
+		}
+	}
+
+	if result := C.rbd_pool_stats_get(C.rados_ioctx_t(pool.Handle()), stats); result < 0 {
+		return nil, errors.New("Failed to fetch pool stats")
+	}
+
+	return &PoolStats{
+		Images:                   uint64(values[0]),
+		ImageProvisionedBytes:    uint64(values[1]),
+		ImageMaxProvisionedBytes: uint64(values[2]),
+		ImageSnapshots:           uint64(values[3]),
+		TrashImages:              uint64(values[4]),
+		TrashProvisionedBytes:    uint64(values[5]),
+		TrashMaxProvisionedBytes: uint64(values[6]),
+		TrashSnapshots:           uint64(values[7]),
+	}, nil
+}
+
+////
+//   Pool purge
+////
+
+type PurgePoolOptions struct {
+	// Move images to the trash rather than removing them outright
+	MoveToTrash bool
+
+	// Skip the safety checks. Protected snapshots are unprotected, and clones
+	// are removed before their parents. Images still open elsewhere will fail
+	// to be removed
+	Force bool
+}
+
+// Returned by PurgePool(). Refused holds the images that failed the safety
+// checks, with the reasons, and Errors those that could not be removed
+type PoolPurgeError struct {
+	Refused map[string][]string
+	Errors  map[string]error
+}
+
+func (err *PoolPurgeError) Error() string {
+	if len(err.Refused) > 0 {
+		names := make([]string, 0, len(err.Refused))
+		for name := range err.Refused {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		messages := make([]string, 0, len(names))
+		for _, name := range names {
+			messages = append(messages, fmt.Sprintf("'%s' %s", name, strings.Join(err.Refused[name], ", ")))
+		}
+
+		return fmt.Sprintf("Refusing to purge pool: %s", strings.Join(messages, "; "))
+	}
+
+	names := make([]string, 0, len(err.Errors))
+	for name := range err.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, err.Errors[name].Error())
+	}
+
+	return fmt.Sprintf("Unable to purge %d images: %s", len(names), strings.Join(messages, "; "))
+}
+
+// Remove (or move to the trash) every image in the pool, or in the namespace
+// its handle is bound to. Unless opts.Force is set, nothing is removed if any
+// image is in use, has clones or has protected snapshots; the offending
+// images are reported in a *PoolPurgeError. opts may be nil
+func PurgePool(pool IOContext, opts *PurgePoolOptions) error {
+	if opts == nil {
+		opts = &PurgePoolOptions{}
+	}
+
+	images, err := ListImages(pool)
+	if err != nil {
+		return err
+	}
+
+	if !opts.Force {
+		refused := make(map[string][]string)
+
+		for _, name := range images {
+			reasons, err := purgeRefusals(pool, name)
+			if err != nil {
+				return err
+			}
+			if len(reasons) > 0 {
+				refused[name] = reasons
+			}
+		}
+
+		if len(refused) > 0 {
+			return &PoolPurgeError{Refused: refused}
+		}
+	}
+
+	// A parent cannot lose its snapshots while clones depend on them, so
+	// images that fail are retried for as long as each pass makes progress
+	pending := images
+	errs := make(map[string]error)
+
+	for len(pending) > 0 {
+		failed := make([]string, 0)
+		errs = make(map[string]error)
+
+		for _, name := range pending {
+			if err := purgeImage(pool, name, opts.MoveToTrash); err != nil {
+				failed = append(failed, name)
+				errs[name] = err
+			}
+		}
+
+		if len(failed) == len(pending) {
+			break
+		}
+		pending = failed
+	}
+
+	if len(errs) > 0 {
+		return &PoolPurgeError{Errors: errs}
+	}
+
+	return nil
+}
+
+// Returns the reasons the image fails PurgePool()'s safety checks
+func purgeRefusals(pool IOContext, imageName string) ([]string, error) {
+	// Read-only, so that our own handle is not counted as a watcher
+	image, err := OpenImageRO(pool, imageName)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	reasons := make([]string, 0)
+
+	inUse, _, err := image.InUse()
+	if err != nil {
+		return nil, err
+	}
+	if inUse {
+		reasons = append(reasons, "is in use")
+	}
+
+	snaps, err := image.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	protected, cloned := false, false
+	for _, snap := range snaps {
+		nsType, err := image.GetSnapNamespaceType(snap.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only user snapshots can be protected, but clones may depend on a
+		// snapshot in any namespace, e.g. one moved to the trash by a
+		// clone-v2 parent removal
+		if nsType == RBD_SNAP_NAMESPACE_TYPE_USER {
+			isProtected, err := image.IsSnapshotProtected(snap.Name)
+			if err != nil {
+				return nil, err
+			}
+			protected = protected || isProtected
+		}
+
+		// Non-user snapshots cannot be looked up by name
+		if result := C.rbd_snap_set_by_id(image.handle, C.uint64_t(snap.ID)); result < 0 {
+			return nil, fmt.Errorf("Unable to set snapshot %d on image '%s'", snap.ID, imageName)
+		}
+		children, err := image.ListChildren()
+		if err != nil {
+			return nil, err
+		}
+		cloned = cloned || len(children) > 0
+	}
+
+	if protected {
+		reasons = append(reasons, "has protected snapshots")
+	}
+	if cloned {
+		reasons = append(reasons, "has clones")
+	}
+
+	return reasons, nil
+}
+
+func purgeImage(pool IOContext, imageName string, moveToTrash bool) error {
+	if moveToTrash {
+		return TrashMove(pool, imageName, 0)
+	}
+
+	image, err := OpenImage(pool, imageName)
+	if err != nil {
+		return err
+	}
+
+	err = image.PurgeSnapshots(true)
+	image.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return RemoveImage2(pool, imageName, false)
+}
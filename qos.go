@@ -0,0 +1,108 @@
+package gorbd
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Per-image QoS throttling, stored as conf_rbd_qos_* image metadata. Limits
+// are in operations or bytes per second, and a zero value leaves the setting
+// unset, falling back to the pool or client configuration. Burst durations
+// are in seconds
+type QoSSettings struct {
+	IOPSLimit      uint64
+	ReadIOPSLimit  uint64
+	WriteIOPSLimit uint64
+	BPSLimit       uint64
+	ReadBPSLimit   uint64
+	WriteBPSLimit  uint64
+
+	IOPSBurst      uint64
+	ReadIOPSBurst  uint64
+	WriteIOPSBurst uint64
+	BPSBurst       uint64
+	ReadBPSBurst   uint64
+	WriteBPSBurst  uint64
+
+	IOPSBurstSeconds      uint64
+	ReadIOPSBurstSeconds  uint64
+	WriteIOPSBurstSeconds uint64
+	BPSBurstSeconds       uint64
+	ReadBPSBurstSeconds   uint64
+	WriteBPSBurstSeconds  uint64
+}
+
+type qosField struct {
+	key   string
+	value *uint64
+}
+
+func (qos *QoSSettings) fields() []qosField {
+	return []qosField{
+		{"conf_rbd_qos_iops_limit", &qos.IOPSLimit},
+		{"conf_rbd_qos_read_iops_limit", &qos.ReadIOPSLimit},
+		{"conf_rbd_qos_write_iops_limit", &qos.WriteIOPSLimit},
+		{"conf_rbd_qos_bps_limit", &qos.BPSLimit},
+		{"conf_rbd_qos_read_bps_limit", &qos.ReadBPSLimit},
+		{"conf_rbd_qos_write_bps_limit", &qos.WriteBPSLimit},
+		{"conf_rbd_qos_iops_burst", &qos.IOPSBurst},
+		{"conf_rbd_qos_read_iops_burst", &qos.ReadIOPSBurst},
+		{"conf_rbd_qos_write_iops_burst", &qos.WriteIOPSBurst},
+		{"conf_rbd_qos_bps_burst", &qos.BPSBurst},
+		{"conf_rbd_qos_read_bps_burst", &qos.ReadBPSBurst},
+		{"conf_rbd_qos_write_bps_burst", &qos.WriteBPSBurst},
+		{"conf_rbd_qos_iops_burst_seconds", &qos.IOPSBurstSeconds},
+		{"conf_rbd_qos_read_iops_burst_seconds", &qos.ReadIOPSBurstSeconds},
+		{"conf_rbd_qos_write_iops_burst_seconds", &qos.WriteIOPSBurstSeconds},
+		{"conf_rbd_qos_bps_burst_seconds", &qos.BPSBurstSeconds},
+		{"conf_rbd_qos_read_bps_burst_seconds", &qos.ReadBPSBurstSeconds},
+		{"conf_rbd_qos_write_bps_burst_seconds", &qos.WriteBPSBurstSeconds},
+	}
+}
+
+// Returns the QoS settings stored on the image itself. Settings inherited
+// from the pool or client configuration are not included
+func (image *Image) GetQoS() (*QoSSettings, error) {
+	metadata, err := image.ListMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	qos := &QoSSettings{}
+
+	for _, field := range qos.fields() {
+		value, ok := metadata[field.key]
+		if !ok {
+			continue
+		}
+
+		if *field.value, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("Invalid QoS setting %s='%s' on image '%s'", field.key, value, image.name)
+		}
+	}
+
+	return qos, nil
+}
+
+// Store QoS settings on the image. Zero fields are removed from the image so
+// that the pool or client configuration applies again
+func (image *Image) SetQoS(qos *QoSSettings) error {
+	metadata, err := image.ListMetadata()
+	if err != nil {
+		return err
+	}
+
+	for _, field := range qos.fields() {
+		if *field.value != 0 {
+			err = image.SetMetadata(field.key, strconv.FormatUint(*field.value, 10))
+		} else if _, ok := metadata[field.key]; ok {
+			err = image.RemoveMetadata(field.key)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
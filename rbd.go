@@ -19,19 +19,97 @@
 package gorbd
 
 // #cgo LDFLAGS: -lrbd -lrados
+// #include <errno.h>
+// #include <stdlib.h>
 // #include <rbd/librbd.h>
+//
+// extern int goProgressCallback(uint64_t, uint64_t, void*);
+// extern int goReadIterateCallback(uint64_t, size_t, char*, void*);
+//
+// static inline int _rbd_flatten_with_progress(rbd_image_t image, uintptr_t arg) {
+// 	return rbd_flatten_with_progress(image, goProgressCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_deep_copy_with_progress(rbd_image_t image, rados_ioctx_t dest_p, const char *destname, rbd_image_options_t opts, uintptr_t arg) {
+// 	return rbd_deep_copy_with_progress(image, dest_p, destname, opts, goProgressCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_read_iterate2(rbd_image_t image, uint64_t ofs, uint64_t len, uintptr_t arg) {
+// 	return rbd_read_iterate2(image, ofs, len, (int (*)(uint64_t, size_t, const char *, void *))goReadIterateCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_rebuild_object_map(rbd_image_t image, uintptr_t arg) {
+// 	return rbd_rebuild_object_map(image, goProgressCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_sparsify_with_progress(rbd_image_t image, size_t sparse_size, uintptr_t arg) {
+// 	return rbd_sparsify_with_progress(image, sparse_size, goProgressCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_resize2(rbd_image_t image, uint64_t size, bool allow_shrink, uintptr_t arg) {
+// 	return rbd_resize2(image, size, allow_shrink, goProgressCallback, (void*)arg);
+// }
 import "C"
 
 import (
+	"context"
 	"errors"
 	"fmt"
-
-	rados "github.com/clbh/go-rados"
+	"io"
+	"time"
+	"unsafe"
 )
 
 // Our bindings version
 const VERSION_MAJOR, VERSION_MINOR, VERSION_PATCH = 1, 1, 0
 
+// Image feature bits, as used by CreateImage2(), CreateImage3(), Features()
+// and UpdateFeatures()
+const (
+	RBD_FEATURE_LAYERING       = uint64(C.RBD_FEATURE_LAYERING)
+	RBD_FEATURE_STRIPINGV2     = uint64(C.RBD_FEATURE_STRIPINGV2)
+	RBD_FEATURE_EXCLUSIVE_LOCK = uint64(C.RBD_FEATURE_EXCLUSIVE_LOCK)
+	RBD_FEATURE_OBJECT_MAP     = uint64(C.RBD_FEATURE_OBJECT_MAP)
+	RBD_FEATURE_FAST_DIFF      = uint64(C.RBD_FEATURE_FAST_DIFF)
+	RBD_FEATURE_DEEP_FLATTEN   = uint64(C.RBD_FEATURE_DEEP_FLATTEN)
+	RBD_FEATURE_JOURNALING     = uint64(C.RBD_FEATURE_JOURNALING)
+	RBD_FEATURE_DATA_POOL      = uint64(C.RBD_FEATURE_DATA_POOL)
+	RBD_FEATURE_OPERATIONS     = uint64(C.RBD_FEATURE_OPERATIONS)
+	RBD_FEATURE_MIGRATING      = uint64(C.RBD_FEATURE_MIGRATING)
+	RBD_FEATURE_NON_PRIMARY    = uint64(C.RBD_FEATURE_NON_PRIMARY)
+)
+
+// Operation feature bits, as returned by Image.OpFeatures()
+const (
+	RBD_OPERATION_FEATURE_CLONE_PARENT = uint64(C.RBD_OPERATION_FEATURE_CLONE_PARENT)
+	RBD_OPERATION_FEATURE_CLONE_CHILD  = uint64(C.RBD_OPERATION_FEATURE_CLONE_CHILD)
+	RBD_OPERATION_FEATURE_GROUP        = uint64(C.RBD_OPERATION_FEATURE_GROUP)
+	RBD_OPERATION_FEATURE_SNAP_TRASH   = uint64(C.RBD_OPERATION_FEATURE_SNAP_TRASH)
+)
+
+// Image flags, as returned by Image.Flags()
+const (
+	RBD_FLAG_OBJECT_MAP_INVALID = uint64(C.RBD_FLAG_OBJECT_MAP_INVALID)
+	RBD_FLAG_FAST_DIFF_INVALID  = uint64(C.RBD_FLAG_FAST_DIFF_INVALID)
+)
+
+// I/O hints accepted by the opFlags argument of data path methods
+const (
+	LIBRADOS_OP_FLAG_FADVISE_RANDOM     = C.LIBRADOS_OP_FLAG_FADVISE_RANDOM
+	LIBRADOS_OP_FLAG_FADVISE_SEQUENTIAL = C.LIBRADOS_OP_FLAG_FADVISE_SEQUENTIAL
+	LIBRADOS_OP_FLAG_FADVISE_WILLNEED   = C.LIBRADOS_OP_FLAG_FADVISE_WILLNEED
+	LIBRADOS_OP_FLAG_FADVISE_DONTNEED   = C.LIBRADOS_OP_FLAG_FADVISE_DONTNEED
+	LIBRADOS_OP_FLAG_FADVISE_NOCACHE    = C.LIBRADOS_OP_FLAG_FADVISE_NOCACHE
+)
+
+// Returned by CompareAndWrite() when the image contents differ from the
+// compare buffer
+var ErrCompareMismatch = errors.New("Image data does not match compare buffer")
+
+// Wrapped by the error RemoveImageWait() returns when the image was still in
+// use by another client when the wait ended; test for it with errors.Is()
+var ErrImageBusy = errors.New("Image is still in use")
+
 // Exported types
 type Image struct {
 	handle   C.rbd_image_t
@@ -68,7 +146,106 @@ func LibraryVersion() (major, minor, extra int) {
 //   Pool operations
 ////
 
-func RemoveImage(pool *rados.Pool, imageName string) error {
+// Create a new format 1 image. An order of 0 selects the librbd default
+// object size (4MB)
+func CreateImage(pool IOContext, name string, size uint64, order int) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	c_order := C.int(order)
+
+	if result := C.rbd_create(C.rados_ioctx_t(pool.Handle()), c_name, C.uint64_t(size), &c_order); result < 0 {
+		return fmt.Errorf("Unable to create image '%s'", name)
+	}
+
+	return nil
+}
+
+// Create a new format 2 image with the requested feature bits
+func CreateImage2(pool IOContext, name string, size uint64, features uint64, order int) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	c_order := C.int(order)
+
+	if result := C.rbd_create2(C.rados_ioctx_t(pool.Handle()), c_name, C.uint64_t(size), C.uint64_t(features), &c_order); result < 0 {
+		return fmt.Errorf("Unable to create image '%s'", name)
+	}
+
+	return nil
+}
+
+// Create a new format 2 image with the requested feature bits and striping
+// parameters. Non-default striping requires RBD_FEATURE_STRIPINGV2
+func CreateImage3(pool IOContext, name string, size uint64, features uint64, order int, stripeUnit uint64, stripeCount uint64) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	c_order := C.int(order)
+
+	if result := C.rbd_create3(C.rados_ioctx_t(pool.Handle()), c_name, C.uint64_t(size), C.uint64_t(features), &c_order, C.uint64_t(stripeUnit), C.uint64_t(stripeCount)); result < 0 {
+		return fmt.Errorf("Unable to create image '%s'", name)
+	}
+
+	return nil
+}
+
+// Create a new image as described by the supplied image options
+func CreateImage4(pool IOContext, name string, size uint64, opts *ImageOptions) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_create4(C.rados_ioctx_t(pool.Handle()), c_name, C.uint64_t(size), opts.handle); result < 0 {
+		return fmt.Errorf("Unable to create image '%s'", name)
+	}
+
+	return nil
+}
+
+// Clone a protected snapshot of a parent image. The parent and child pools
+// may differ, as may the rados namespaces their handles are bound to. opts
+// may be nil to use the default clone options
+func CloneImage(parentPool IOContext, parentName string, snapName string, childPool IOContext, childName string, opts *ImageOptions) error {
+	c_parentName := C.CString(parentName)
+	defer C.free(unsafe.Pointer(c_parentName))
+	c_snapName := C.CString(snapName)
+	defer C.free(unsafe.Pointer(c_snapName))
+	c_childName := C.CString(childName)
+	defer C.free(unsafe.Pointer(c_childName))
+
+	if opts == nil {
+		opts = NewImageOptions()
+		defer opts.Destroy()
+	}
+
+	if result := C.rbd_clone3(C.rados_ioctx_t(parentPool.Handle()), c_parentName, c_snapName,
+		C.rados_ioctx_t(childPool.Handle()), c_childName, opts.handle); result < 0 {
+		return fmt.Errorf("Unable to clone '%s@%s' to '%s'", parentName, snapName, childName)
+	}
+
+	return nil
+}
+
+// Clone a parent snapshot identified by its snapshot ID rather than its name.
+// This allows cloning from snapshots which no longer have a user-visible name,
+// such as clone v2 snapshots that have been moved to the trash namespace
+func CloneImageBySnapID(parentPool IOContext, parentName string, snapID uint64, childPool IOContext, childName string, opts *ImageOptions) error {
+	c_parentName := C.CString(parentName)
+	defer C.free(unsafe.Pointer(c_parentName))
+	c_childName := C.CString(childName)
+	defer C.free(unsafe.Pointer(c_childName))
+
+	if opts == nil {
+		opts = NewImageOptions()
+		defer opts.Destroy()
+	}
+
+	if result := C.rbd_clone4(C.rados_ioctx_t(parentPool.Handle()), c_parentName, C.uint64_t(snapID),
+		C.rados_ioctx_t(childPool.Handle()), c_childName, opts.handle); result < 0 {
+		return fmt.Errorf("Unable to clone '%s' snapshot %d to '%s'", parentName, snapID, childName)
+	}
+
+	return nil
+}
+
+func RemoveImage(pool IOContext, imageName string) error {
 	// TODO: Release memory allocated by C.CString()
 	if result := C.rbd_remove(C.rados_ioctx_t(pool.Handle()), C.CString(imageName)); result < 0 {
 		return errors.New("Failed to remove image")
@@ -77,7 +254,36 @@ func RemoveImage(pool *rados.Pool, imageName string) error {
 	return nil
 }
 
-func RenameImage(pool *rados.Pool, srcName string, dstName string) error {
+// Remove an image, as RemoveImage(), but while the image is still in use
+// (typically because a client that just unmapped it has not yet timed out its
+// watch) keep retrying every interval until ctx is done. If ctx ends first an
+// error wrapping ErrImageBusy, and naming why ctx ended, is returned
+func RemoveImageWait(ctx context.Context, pool IOContext, imageName string, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("Retry interval must be positive")
+	}
+
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	for {
+		result := C.rbd_remove(C.rados_ioctx_t(pool.Handle()), c_imageName)
+		if result >= 0 {
+			return nil
+		}
+		if result != -C.EBUSY {
+			return errors.New("Failed to remove image")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrImageBusy, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+func RenameImage(pool IOContext, srcName string, dstName string) error {
 	// TODO: Release memory allocated by C.CString()
 	if result := C.rbd_rename(C.rados_ioctx_t(pool.Handle()), C.CString(srcName), C.CString(dstName)); result < 0 {
 		return errors.New("Failed to rename image")
@@ -86,7 +292,7 @@ func RenameImage(pool *rados.Pool, srcName string, dstName string) error {
 	return nil
 }
 
-func ListImages(pool *rados.Pool) ([]string, error) {
+func ListImages(pool IOContext) ([]string, error) {
 	var buf [65536]C.char
 	var size C.size_t = 65536
 
@@ -114,43 +320,92 @@ func ListImages(pool *rados.Pool) ([]string, error) {
 	return images, nil
 }
 
+// Reports whether the image exists. Errors other than the image not being
+// found are returned as errors rather than as false
+func ImageExists(pool IOContext, name string) (bool, error) {
+	var handle C.rbd_image_t
+
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	// A read-only open is the cheapest probe librbd offers; it does not
+	// register a watch on the header
+	result := C.rbd_open_read_only(C.rados_ioctx_t(pool.Handle()), c_name, &handle, nil)
+	if result == -C.ENOENT {
+		return false, nil
+	}
+	if result < 0 {
+		return false, fmt.Errorf("Unable to check for image '%s'", name)
+	}
+
+	C.rbd_close(handle)
+
+	return true, nil
+}
+
 ////
 //   Image methods
 ////
-func OpenImage(pool *rados.Pool, name string) (*Image, error) {
-	var handle C.rbd_image_t
 
-	// TODO: Release memory allocated by C.CString()
-	if result := C.rbd_open(C.rados_ioctx_t(pool.Handle()), C.CString(name), &handle, nil); result < 0 {
-		return nil, errors.New("Failed to open RBD image")
-	}
+// Open an image for reading and writing
+func OpenImage(pool IOContext, name string) (*Image, error) {
+	return openImage(pool, name, "", false)
+}
 
-	return &Image{
-		handle: handle,
-		name:   name,
-	}, nil
+// Open an image read-only. Read-only handles never take the exclusive lock,
+// so they are suitable for scanners, exporters and monitoring
+func OpenImageRO(pool IOContext, name string) (*Image, error) {
+	return openImage(pool, name, "", true)
 }
 
-func OpenImageRO(pool *rados.Pool, name string) (*Image, error) {
-	var handle C.rbd_image_t
+// Alias of OpenImageRO()
+func OpenImageReadOnly(pool IOContext, name string) (*Image, error) {
+	return OpenImageRO(pool, name)
+}
 
-	// TODO: Release memory allocated by C.CString()
-	if result := C.rbd_open_read_only(C.rados_ioctx_t(pool.Handle()), C.CString(name), &handle, nil); result < 0 {
-		return nil, errors.New("Failed to open RBD image")
-	}
+// Open an image directly at a snapshot, giving a point-in-time view of its
+// contents without a separate SetSnapshot() call. An empty snapshot name opens
+// the image head, as OpenImage() does
+func OpenImageSnapshot(pool IOContext, name string, snapshot string) (*Image, error) {
+	return openImage(pool, name, snapshot, false)
+}
 
-	return &Image{
-		handle:   handle,
-		name:     name,
-		readonly: true,
-	}, nil
+// Read-only variant of OpenImageSnapshot()
+func OpenImageSnapshotRO(pool IOContext, name string, snapshot string) (*Image, error) {
+	return openImage(pool, name, snapshot, true)
 }
 
-func OpenImageSnapshot(pool *rados.Pool, name string, snapshot string) (*Image, error) {
+// Open an image by its ID rather than its name. IDs are stable across
+// renames and are what trash and mirroring metadata record
+func OpenImageByID(pool IOContext, id string) (*Image, error) {
+	return openImageByID(pool, id, false)
+}
+
+// Read-only variant of OpenImageByID()
+func OpenImageByIDRO(pool IOContext, id string) (*Image, error) {
+	return openImageByID(pool, id, true)
+}
+
+func openImage(pool IOContext, name string, snapshot string, readonly bool) (*Image, error) {
 	var handle C.rbd_image_t
+	var result C.int
 
-	// TODO: Release memory allocated by C.CString()
-	if result := C.rbd_open(C.rados_ioctx_t(pool.Handle()), C.CString(name), &handle, C.CString(snapshot)); result < 0 {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	var c_snapshot *C.char
+	if snapshot != "" {
+		c_snapshot = C.CString(snapshot)
+		defer C.free(unsafe.Pointer(c_snapshot))
+	}
+
+	if readonly {
+		result = C.rbd_open_read_only(C.rados_ioctx_t(pool.Handle()), c_name, &handle, c_snapshot)
+	} else {
+		result = C.rbd_open(C.rados_ioctx_t(pool.Handle()), c_name, &handle, c_snapshot)
+	}
+
+	if result < 0 {
 		return nil, errors.New("Failed to open RBD image")
 	}
 
@@ -158,31 +413,60 @@ func OpenImageSnapshot(pool *rados.Pool, name string, snapshot string) (*Image,
 		handle:   handle,
 		name:     name,
 		snapshot: snapshot,
+		readonly: readonly,
 	}, nil
 }
 
-func OpenImageSnapshotRO(pool *rados.Pool, name string, snapshot string) (*Image, error) {
+func openImageByID(pool IOContext, id string, readonly bool) (*Image, error) {
 	var handle C.rbd_image_t
+	var result C.int
 
-	// TODO: Release memory allocated by C.CString()
-	if result := C.rbd_open_read_only(C.rados_ioctx_t(pool.Handle()), C.CString(name), &handle, C.CString(snapshot)); result < 0 {
-		return nil, errors.New("Failed to open RBD image")
+	c_id := C.CString(id)
+	defer C.free(unsafe.Pointer(c_id))
+
+	if readonly {
+		result = C.rbd_open_by_id_read_only(C.rados_ioctx_t(pool.Handle()), c_id, &handle, nil)
+	} else {
+		result = C.rbd_open_by_id(C.rados_ioctx_t(pool.Handle()), c_id, &handle, nil)
 	}
 
-	return &Image{
+	if result < 0 {
+		return nil, fmt.Errorf("Failed to open RBD image with ID '%s'", id)
+	}
+
+	image := &Image{
 		handle:   handle,
-		name:     name,
-		snapshot: snapshot,
-		readonly: true,
-	}, nil
+		readonly: readonly,
+	}
+
+	// The name is informational only, so failing to look it up is not fatal
+	size := C.size_t(64)
+	for {
+		buf := make([]C.char, size)
+
+		result := C.rbd_get_name(handle, &buf[0], &size)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result == 0 {
+			image.name = C.GoString(&buf[0])
+		}
+
+		return image, nil
+	}
 }
 
 func (image *Image) Close() {
+	// Already closed by AioClose()
+	if image.handle == nil {
+		return
+	}
+
 	C.rbd_close(image.handle)
 }
 
 // Copy an image to a destination pool with the specified destination image name
-func (image *Image) CopyToName(destPool *rados.Pool, destImage string) error {
+func (image *Image) CopyToName(destPool IOContext, destImage string) error {
 	// rbd_copy() is a syncronous function. It will not return until the copy
 	// operation has completed
 	// TODO: Release memory allocated by C.CString()
@@ -204,6 +488,112 @@ func (image *Image) CopyToImage(dest *Image) error {
 	return nil
 }
 
+// Copy an image, including its snapshots and any clone parent linkage, to a
+// destination pool with the specified destination image name. opts and
+// progress may be nil
+func (image *Image) DeepCopy(destPool IOContext, destImage string, opts *ImageOptions, progress ProgressFunc) error {
+	c_destImage := C.CString(destImage)
+	defer C.free(unsafe.Pointer(c_destImage))
+
+	if opts == nil {
+		opts = NewImageOptions()
+		defer opts.Destroy()
+	}
+
+	var result C.int
+
+	if progress == nil {
+		result = C.rbd_deep_copy(image.handle, C.rados_ioctx_t(destPool.Handle()), c_destImage, opts.handle)
+	} else {
+		id := registerCallback(progress)
+		defer unregisterCallback(id)
+
+		result = C._rbd_deep_copy_with_progress(image.handle, C.rados_ioctx_t(destPool.Handle()), c_destImage, opts.handle, C.uintptr_t(id))
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to deep copy image '%s' to '%s'", image.name, destImage)
+	}
+
+	return nil
+}
+
+func (image *Image) AccessTimestamp() (time.Time, error) {
+	var timestamp C.struct_timespec
+
+	if result := C.rbd_get_access_timestamp(image.handle, &timestamp); result < 0 {
+		return time.Time{}, fmt.Errorf("Unable to get access timestamp of image '%s'", image.name)
+	}
+
+	return time.Unix(int64(timestamp.tv_sec), int64(timestamp.tv_nsec)), nil
+}
+
+// Returns the prefix shared by the names of the image's rados data objects
+func (image *Image) BlockNamePrefix() (string, error) {
+	size := 32
+
+	for {
+		buf := make([]C.char, size)
+
+		result := C.rbd_get_block_name_prefix(image.handle, &buf[0], C.size_t(size))
+		if result == -C.ERANGE {
+			size *= 2
+			continue
+		}
+		if result < 0 {
+			return "", fmt.Errorf("Unable to get block name prefix of image '%s'", image.name)
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
+// Atomically write data to the image at the given offset, provided the
+// existing contents match cmp. On a mismatch ErrCompareMismatch is returned
+// along with the offset of the first differing byte
+func (image *Image) CompareAndWrite(offset uint64, cmp []byte, data []byte) (uint64, error) {
+	if len(cmp) != len(data) {
+		return 0, errors.New("Compare and write buffers must be the same length")
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var mismatch C.uint64_t
+
+	result := C.rbd_compare_and_write(image.handle, C.uint64_t(offset), C.size_t(len(data)),
+		(*C.char)(unsafe.Pointer(&cmp[0])), (*C.char)(unsafe.Pointer(&data[0])), &mismatch, 0)
+	if result == -C.EILSEQ {
+		return uint64(mismatch), ErrCompareMismatch
+	}
+	if result < 0 {
+		return 0, fmt.Errorf("Unable to compare and write at offset %d of image '%s'", offset, image.name)
+	}
+
+	return 0, nil
+}
+
+func (image *Image) CreateTimestamp() (time.Time, error) {
+	var timestamp C.struct_timespec
+
+	if result := C.rbd_get_create_timestamp(image.handle, &timestamp); result < 0 {
+		return time.Time{}, fmt.Errorf("Unable to get creation timestamp of image '%s'", image.name)
+	}
+
+	return time.Unix(int64(timestamp.tv_sec), int64(timestamp.tv_nsec)), nil
+}
+
+// Returns the ID of the pool holding the image's data objects. This is the
+// image's own pool unless it was created with a separate data pool
+func (image *Image) DataPoolID() (int64, error) {
+	result := C.rbd_get_data_pool_id(image.handle)
+	if result < 0 {
+		return 0, fmt.Errorf("Unable to get data pool of image '%s'", image.name)
+	}
+
+	return int64(result), nil
+}
+
 func (image *Image) CreateSnapshot(name string) error {
 	// TODO: Release unmanaged memory allocated by C.CString()
 	if result := C.rbd_snap_create(image.handle, C.CString(name)); result < 0 {
@@ -213,6 +603,69 @@ func (image *Image) CreateSnapshot(name string) error {
 	return nil
 }
 
+// Discard a range of the image, deallocating the underlying objects where
+// possible. Discarded regions read back as zeroes
+func (image *Image) Discard(offset uint64, length uint64) error {
+	if result := C.rbd_discard(image.handle, C.uint64_t(offset), C.uint64_t(length)); result < 0 {
+		return fmt.Errorf("Unable to discard %d bytes at offset %d of image '%s'", length, offset, image.name)
+	}
+
+	return nil
+}
+
+// Returns the image's RBD_FEATURE_* bits
+func (image *Image) Features() (uint64, error) {
+	var features C.uint64_t
+
+	if result := C.rbd_get_features(image.handle, &features); result < 0 {
+		return 0, fmt.Errorf("Unable to get features of image '%s'", image.name)
+	}
+
+	return uint64(features), nil
+}
+
+// Returns the image's RBD_FLAG_* flags. When the object map or fast-diff
+// data is flagged invalid, diffs fall back to scanning the image
+func (image *Image) Flags() (uint64, error) {
+	var flags C.uint64_t
+
+	if result := C.rbd_get_flags(image.handle, &flags); result < 0 {
+		return 0, fmt.Errorf("Unable to get flags of image '%s'", image.name)
+	}
+
+	return uint64(flags), nil
+}
+
+// Copy all data from the parent into this clone, removing its dependency on
+// the parent snapshot. progress may be nil
+func (image *Image) Flatten(progress ProgressFunc) error {
+	var result C.int
+
+	if progress == nil {
+		result = C.rbd_flatten(image.handle)
+	} else {
+		id := registerCallback(progress)
+		defer unregisterCallback(id)
+
+		result = C._rbd_flatten_with_progress(image.handle, C.uintptr_t(id))
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to flatten image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Flush any writes held in the librbd client cache out to the cluster
+func (image *Image) Flush() error {
+	if result := C.rbd_flush(image.handle); result < 0 {
+		return fmt.Errorf("Unable to flush image '%s'", image.name)
+	}
+
+	return nil
+}
+
 func (image *Image) Format() int {
 	var isOld C.uint8_t
 
@@ -236,6 +689,27 @@ func (image *Image) Handle() C.rbd_image_t {
 	return image.handle
 }
 
+// Returns the image's ID, which unlike its name is stable for the lifetime of
+// the image. Format 1 images have no ID
+func (image *Image) ID() (string, error) {
+	size := 32
+
+	for {
+		buf := make([]C.char, size)
+
+		result := C.rbd_get_id(image.handle, &buf[0], C.size_t(size))
+		if result == -C.ERANGE {
+			size *= 2
+			continue
+		}
+		if result < 0 {
+			return "", fmt.Errorf("Unable to get ID of image '%s'", image.name)
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
 func (image *Image) Info() (*ImageInfo, error) {
 	var info C.rbd_image_info_t
 
@@ -252,11 +726,86 @@ func (image *Image) Info() (*ImageInfo, error) {
 	}, nil
 }
 
+// Drop any data held in the librbd client cache, so that subsequent reads
+// see changes made by other clients
+func (image *Image) InvalidateCache() error {
+	if result := C.rbd_invalidate_cache(image.handle); result < 0 {
+		return fmt.Errorf("Unable to invalidate cache of image '%s'", image.name)
+	}
+
+	return nil
+}
+
+func (image *Image) ModifyTimestamp() (time.Time, error) {
+	var timestamp C.struct_timespec
+
+	if result := C.rbd_get_modify_timestamp(image.handle, &timestamp); result < 0 {
+		return time.Time{}, fmt.Errorf("Unable to get modification timestamp of image '%s'", image.name)
+	}
+
+	return time.Unix(int64(timestamp.tv_sec), int64(timestamp.tv_nsec)), nil
+}
 
 func (image *Image) Name() string {
 	return image.name
 }
 
+// Returns the image's RBD_OPERATION_FEATURE_* bits, which record operations
+// in effect on the image such as clone v2 parentage or group membership.
+// These are only meaningful when RBD_FEATURE_OPERATIONS is enabled
+func (image *Image) OpFeatures() (uint64, error) {
+	var features C.uint64_t
+
+	if result := C.rbd_get_op_features(image.handle, &features); result < 0 {
+		return 0, fmt.Errorf("Unable to get op features of image '%s'", image.name)
+	}
+
+	return uint64(features), nil
+}
+
+// Read from the image at the given offset into buf. As with io.ReaderAt, a
+// read that returns fewer than len(buf) bytes is accompanied by an error, which
+// is io.EOF if the end of the image was reached
+func (image *Image) Read(offset uint64, buf []byte) (int, error) {
+	return image.Read2(offset, buf, 0)
+}
+
+// As Read(), with LIBRADOS_OP_FLAG_FADVISE_* hints for the read
+func (image *Image) Read2(offset uint64, buf []byte, opFlags int) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	result := C.rbd_read2(image.handle, C.uint64_t(offset), C.size_t(len(buf)), (*C.char)(unsafe.Pointer(&buf[0])), C.int(opFlags))
+	if result < 0 {
+		return 0, fmt.Errorf("Unable to read from image '%s' at offset %d", image.name, offset)
+	}
+
+	if int(result) < len(buf) {
+		return int(result), io.EOF
+	}
+
+	return int(result), nil
+}
+
+// Rebuild an invalid object map, clearing RBD_FLAG_OBJECT_MAP_INVALID.
+// progress may be nil
+func (image *Image) RebuildObjectMap(progress ProgressFunc) error {
+	var id uintptr
+
+	// librbd always reports progress; ID 0 is never registered
+	if progress != nil {
+		id = registerCallback(progress)
+		defer unregisterCallback(id)
+	}
+
+	if result := C._rbd_rebuild_object_map(image.handle, C.uintptr_t(id)); result < 0 {
+		return fmt.Errorf("Unable to rebuild object map of image '%s'", image.name)
+	}
+
+	return nil
+}
+
 func (image *Image) RemoveSnapshot(name string) error {
 	// TODO: Release unmanaged memory allocated by C.CString()
 	if result := C.rbd_snap_remove(image.handle, C.CString(name)); result < 0 {
@@ -274,6 +823,23 @@ func (image *Image) Resize(size uint64) error {
 	return nil
 }
 
+// As Resize(), but shrinking the image (and discarding the data beyond the
+// new size) is refused unless allowShrink is set. progress may be nil
+func (image *Image) Resize2(size uint64, allowShrink bool, progress ProgressFunc) error {
+	var id uintptr
+
+	if progress != nil {
+		id = registerCallback(progress)
+		defer unregisterCallback(id)
+	}
+
+	if result := C._rbd_resize2(image.handle, C.uint64_t(size), C.bool(allowShrink), C.uintptr_t(id)); result < 0 {
+		return fmt.Errorf("Unable to resize image '%s' to size %d", image.name, size)
+	}
+
+	return nil
+}
+
 func (image *Image) Size() uint64 {
 	var size C.uint64_t
 
@@ -283,3 +849,120 @@ func (image *Image) Size() uint64 {
 
 	return uint64(size)
 }
+
+// Read length bytes of the image starting at offset, calling fn for each
+// allocated extent. Unallocated regions are skipped rather than returned as
+// zeroes. An error returned by fn stops the read and is returned
+func (image *Image) SparseRead(offset uint64, length uint64, fn func(offset uint64, data []byte) error) error {
+	state := &readIterateState{fn: fn}
+	id := registerCallback(state)
+	defer unregisterCallback(id)
+
+	result := C._rbd_read_iterate2(image.handle, C.uint64_t(offset), C.uint64_t(length), C.uintptr_t(id))
+	if state.err != nil {
+		return state.err
+	}
+	if result < 0 {
+		return fmt.Errorf("Unable to read from image '%s' at offset %d", image.name, offset)
+	}
+
+	return nil
+}
+
+// Deallocate zeroed regions of the image, in chunks of sparseSize bytes
+// (a power of two no smaller than 4096). progress may be nil
+func (image *Image) Sparsify(sparseSize uint64, progress ProgressFunc) error {
+	var result C.int
+
+	if progress == nil {
+		result = C.rbd_sparsify(image.handle, C.size_t(sparseSize))
+	} else {
+		id := registerCallback(progress)
+		defer unregisterCallback(id)
+
+		result = C._rbd_sparsify_with_progress(image.handle, C.size_t(sparseSize), C.uintptr_t(id))
+	}
+
+	if result < 0 {
+		return fmt.Errorf("Unable to sparsify image '%s'", image.name)
+	}
+
+	return nil
+}
+
+func (image *Image) StripeCount() (uint64, error) {
+	var count C.uint64_t
+
+	if result := C.rbd_get_stripe_count(image.handle, &count); result < 0 {
+		return 0, fmt.Errorf("Unable to get stripe count of image '%s'", image.name)
+	}
+
+	return uint64(count), nil
+}
+
+func (image *Image) StripeUnit() (uint64, error) {
+	var unit C.uint64_t
+
+	if result := C.rbd_get_stripe_unit(image.handle, &unit); result < 0 {
+		return 0, fmt.Errorf("Unable to get stripe unit of image '%s'", image.name)
+	}
+
+	return uint64(unit), nil
+}
+
+// Enable or disable the RBD_FEATURE_* bits in mask on a live image. Not all
+// features can be changed after creation
+func (image *Image) UpdateFeatures(mask uint64, enable bool) error {
+	var enabled C.uint8_t
+	if enable {
+		enabled = 1
+	}
+
+	if result := C.rbd_update_features(image.handle, C.uint64_t(mask), enabled); result < 0 {
+		return fmt.Errorf("Unable to update features of image '%s'", image.name)
+	}
+
+	return nil
+}
+
+// Write data to the image at the given offset. The image is never grown: a
+// write extending past the end is clipped to the image size and returns the
+// number of bytes written with io.ErrShortWrite, so callers must check the
+// count. Only a write starting at or beyond the end fails outright
+func (image *Image) Write(offset uint64, data []byte) (int, error) {
+	return image.Write2(offset, data, 0)
+}
+
+// As Write(), with LIBRADOS_OP_FLAG_FADVISE_* hints for the write
+func (image *Image) Write2(offset uint64, data []byte, opFlags int) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	result := C.rbd_write2(image.handle, C.uint64_t(offset), C.size_t(len(data)), (*C.char)(unsafe.Pointer(&data[0])), C.int(opFlags))
+	if result < 0 {
+		return 0, fmt.Errorf("Unable to write to image '%s' at offset %d", image.name, offset)
+	}
+
+	if int(result) < len(data) {
+		return int(result), io.ErrShortWrite
+	}
+
+	return int(result), nil
+}
+
+// Fill length bytes of the image starting at offset with repeated copies of
+// pattern. length must be a multiple of the pattern length
+func (image *Image) WriteSame(offset uint64, length uint64, pattern []byte, opFlags int) error {
+	if len(pattern) == 0 || length%uint64(len(pattern)) != 0 {
+		return errors.New("Write length must be a multiple of the pattern length")
+	}
+
+	result := C.rbd_writesame(image.handle, C.uint64_t(offset), C.size_t(length),
+		(*C.char)(unsafe.Pointer(&pattern[0])), C.size_t(len(pattern)), C.int(opFlags))
+	if result < 0 {
+		return fmt.Errorf("Unable to write %d bytes at offset %d of image '%s'", length, offset, image.name)
+	}
+
+	return nil
+}
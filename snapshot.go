@@ -0,0 +1,523 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+//
+// extern int goProgressCallback(uint64_t, uint64_t, void*);
+//
+// static inline int _rbd_snap_create2(rbd_image_t image, const char *snapname, uint32_t flags, uintptr_t arg) {
+// 	return rbd_snap_create2(image, snapname, flags, goProgressCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_snap_remove2(rbd_image_t image, const char *snapname, uint32_t flags, uintptr_t arg) {
+// 	return rbd_snap_remove2(image, snapname, flags, goProgressCallback, (void*)arg);
+// }
+//
+// extern int goDiffIterateCallback(uint64_t, size_t, int, void*);
+//
+// static inline int _rbd_diff_iterate2(rbd_image_t image, const char *fromsnapname, uint64_t ofs, uint64_t len, uintptr_t arg) {
+// 	return rbd_diff_iterate2(image, fromsnapname, ofs, len, 1, 0, goDiffIterateCallback, (void*)arg);
+// }
+import "C"
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// Snapshot namespace types. User snapshots are the ones created through
+// CreateSnapshot(); the others are maintained by librbd itself
+const (
+	RBD_SNAP_NAMESPACE_TYPE_USER   = C.RBD_SNAP_NAMESPACE_TYPE_USER
+	RBD_SNAP_NAMESPACE_TYPE_GROUP  = C.RBD_SNAP_NAMESPACE_TYPE_GROUP
+	RBD_SNAP_NAMESPACE_TYPE_TRASH  = C.RBD_SNAP_NAMESPACE_TYPE_TRASH
+	RBD_SNAP_NAMESPACE_TYPE_MIRROR = C.RBD_SNAP_NAMESPACE_TYPE_MIRROR
+)
+
+// Snapshot creation flags, as accepted by Image.CreateSnapshot2() and
+// Group.CreateSnapshot2(). By default snapshot creation fails if a quiesce
+// watcher reports an error
+const (
+	RBD_SNAP_CREATE_SKIP_QUIESCE         = uint32(C.RBD_SNAP_CREATE_SKIP_QUIESCE)
+	RBD_SNAP_CREATE_IGNORE_QUIESCE_ERROR = uint32(C.RBD_SNAP_CREATE_IGNORE_QUIESCE_ERROR)
+)
+
+// Snapshot removal flags, as accepted by RemoveSnapshot2()
+const (
+	RBD_SNAP_REMOVE_UNPROTECT = uint32(C.RBD_SNAP_REMOVE_UNPROTECT)
+	RBD_SNAP_REMOVE_FLATTEN   = uint32(C.RBD_SNAP_REMOVE_FLATTEN)
+	RBD_SNAP_REMOVE_FORCE     = uint32(C.RBD_SNAP_REMOVE_FORCE)
+)
+
+// Mirror snapshot states
+const (
+	RBD_SNAP_MIRROR_STATE_PRIMARY             = C.RBD_SNAP_MIRROR_STATE_PRIMARY
+	RBD_SNAP_MIRROR_STATE_PRIMARY_DEMOTED     = C.RBD_SNAP_MIRROR_STATE_PRIMARY_DEMOTED
+	RBD_SNAP_MIRROR_STATE_NON_PRIMARY         = C.RBD_SNAP_MIRROR_STATE_NON_PRIMARY
+	RBD_SNAP_MIRROR_STATE_NON_PRIMARY_DEMOTED = C.RBD_SNAP_MIRROR_STATE_NON_PRIMARY_DEMOTED
+)
+
+type SnapInfo struct {
+	ID        uint64
+	Size      uint64
+	Name      string
+	Timestamp time.Time
+}
+
+// Returns the snapshots of the image in every namespace, not only user
+// snapshots. Use GetSnapNamespaceType() to tell them apart
+func (image *Image) ListSnapshots() ([]SnapInfo, error) {
+	max := C.int(16)
+
+	for {
+		snaps := make([]C.rbd_snap_info_t, max)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := C.rbd_snap_list(image.handle, &snaps[0], &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list snapshots of image '%s'", image.name)
+		}
+
+		info := make([]SnapInfo, 0, int(result))
+		for _, snap := range snaps[:result] {
+			info = append(info, SnapInfo{
+				ID:   uint64(snap.id),
+				Size: uint64(snap.size),
+				Name: C.GoString(snap.name),
+			})
+		}
+
+		C.rbd_snap_list_end(&snaps[0])
+
+		for i := range info {
+			timestamp, err := image.GetSnapTimestamp(info[i].ID)
+			if err != nil {
+				return nil, err
+			}
+
+			info[i].Timestamp = timestamp
+		}
+
+		return info, nil
+	}
+}
+
+// Returns the number of snapshots of the image in every namespace, as
+// ListSnapshots() would return, without fetching their details
+func (image *Image) SnapshotCount() (uint64, error) {
+	var max C.int
+
+	// With no room for entries rbd_snap_list() reports the space needed,
+	// which is one more than the number of snapshots
+	if result := C.rbd_snap_list(image.handle, nil, &max); result != -C.ERANGE {
+		if result < 0 {
+			return 0, fmt.Errorf("Unable to count snapshots of image '%s'", image.name)
+		}
+
+		return 0, nil
+	}
+
+	return uint64(max - 1), nil
+}
+
+// Protect a snapshot from removal. Snapshots must be protected before they
+// can be cloned (unless clone v2 is in use)
+func (image *Image) ProtectSnapshot(name string) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_snap_protect(image.handle, c_name); result < 0 {
+		return fmt.Errorf("Unable to protect snapshot '%s' of image '%s'", name, image.name)
+	}
+
+	return nil
+}
+
+// Allow a snapshot to be removed again. Fails while the snapshot has clones
+func (image *Image) UnprotectSnapshot(name string) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_snap_unprotect(image.handle, c_name); result < 0 {
+		return fmt.Errorf("Unable to unprotect snapshot '%s' of image '%s'", name, image.name)
+	}
+
+	return nil
+}
+
+func (image *Image) IsSnapshotProtected(name string) (bool, error) {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	var protected C.int
+
+	if result := C.rbd_snap_is_protected(image.handle, c_name, &protected); result < 0 {
+		return false, fmt.Errorf("Unable to check protection of snapshot '%s' of image '%s'", name, image.name)
+	}
+
+	return protected != 0, nil
+}
+
+func (image *Image) RenameSnapshot(oldName string, newName string) error {
+	c_oldName := C.CString(oldName)
+	defer C.free(unsafe.Pointer(c_oldName))
+	c_newName := C.CString(newName)
+	defer C.free(unsafe.Pointer(c_newName))
+
+	if result := C.rbd_snap_rename(image.handle, c_oldName, c_newName); result < 0 {
+		return fmt.Errorf("Unable to rename snapshot '%s' of image '%s' to '%s'", oldName, image.name, newName)
+	}
+
+	return nil
+}
+
+func (image *Image) SnapshotExists(name string) (bool, error) {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	var exists C.bool
+
+	if result := C.rbd_snap_exists(image.handle, c_name, &exists); result < 0 {
+		return false, fmt.Errorf("Unable to look up snapshot '%s' of image '%s'", name, image.name)
+	}
+
+	return bool(exists), nil
+}
+
+func (image *Image) GetSnapID(name string) (uint64, error) {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+	var id C.uint64_t
+
+	if result := C.rbd_snap_get_id(image.handle, c_name, &id); result < 0 {
+		return 0, fmt.Errorf("Unable to look up snapshot '%s' of image '%s'", name, image.name)
+	}
+
+	return uint64(id), nil
+}
+
+func (image *Image) GetSnapName(id uint64) (string, error) {
+	size := C.size_t(64)
+
+	for {
+		buf := make([]C.char, size)
+
+		// On -ERANGE, size is updated to the length required
+		result := C.rbd_snap_get_name(image.handle, C.uint64_t(id), &buf[0], &size)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return "", fmt.Errorf("Unable to look up snapshot %d of image '%s'", id, image.name)
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
+// Point the image handle at a snapshot, so that subsequent reads return the
+// snapshot's point-in-time contents
+func (image *Image) SetSnapshot(name string) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C.rbd_snap_set(image.handle, c_name); result < 0 {
+		return fmt.Errorf("Unable to set snapshot '%s' on image '%s'", name, image.name)
+	}
+
+	image.snapshot = name
+
+	return nil
+}
+
+// Point the image handle back at the image head
+func (image *Image) SetNoSnapshot() error {
+	if result := C.rbd_snap_set(image.handle, nil); result < 0 {
+		return fmt.Errorf("Unable to unset snapshot on image '%s'", image.name)
+	}
+
+	image.snapshot = ""
+
+	return nil
+}
+
+// Returns the maximum number of snapshots allowed on the image. An image
+// without a limit reports math.MaxUint64
+func (image *Image) GetSnapshotLimit() (uint64, error) {
+	var limit C.uint64_t
+
+	if result := C.rbd_snap_get_limit(image.handle, &limit); result < 0 {
+		return 0, fmt.Errorf("Unable to get snapshot limit of image '%s'", image.name)
+	}
+
+	return uint64(limit), nil
+}
+
+// Cap the number of snapshots allowed on the image. Setting the limit to
+// math.MaxUint64 removes it
+func (image *Image) SetSnapshotLimit(limit uint64) error {
+	if result := C.rbd_snap_set_limit(image.handle, C.uint64_t(limit)); result < 0 {
+		return fmt.Errorf("Unable to set snapshot limit of image '%s' to %d", image.name, limit)
+	}
+
+	return nil
+}
+
+// Returns the time at which a snapshot was created
+func (image *Image) GetSnapTimestamp(id uint64) (time.Time, error) {
+	var timestamp C.struct_timespec
+
+	if result := C.rbd_snap_get_timestamp(image.handle, C.uint64_t(id), &timestamp); result < 0 {
+		return time.Time{}, fmt.Errorf("Unable to get timestamp of snapshot %d of image '%s'", id, image.name)
+	}
+
+	return time.Unix(int64(timestamp.tv_sec), int64(timestamp.tv_nsec)), nil
+}
+
+func (image *Image) GetSnapNamespaceType(id uint64) (int, error) {
+	var nsType C.rbd_snap_namespace_type_t
+
+	if result := C.rbd_snap_get_namespace_type(image.handle, C.uint64_t(id), &nsType); result < 0 {
+		return 0, fmt.Errorf("Unable to get namespace of snapshot %d of image '%s'", id, image.name)
+	}
+
+	return int(nsType), nil
+}
+
+// Returns the original name of a snapshot in the trash namespace. Clone v2
+// moves snapshots there when they are removed while clones still use them
+func (image *Image) GetSnapTrashNamespace(id uint64) (string, error) {
+	size := 64
+
+	for {
+		buf := make([]C.char, size)
+
+		result := C.rbd_snap_get_trash_namespace(image.handle, C.uint64_t(id), &buf[0], C.size_t(size))
+		if result == -C.ERANGE {
+			size *= 2
+			continue
+		}
+		if result < 0 {
+			return "", fmt.Errorf("Unable to get trash namespace of snapshot %d of image '%s'", id, image.name)
+		}
+
+		return C.GoString(&buf[0]), nil
+	}
+}
+
+// Details of a snapshot taken as part of a group snapshot
+type SnapGroupNamespace struct {
+	GroupPool     int64
+	GroupName     string
+	GroupSnapName string
+}
+
+func (image *Image) GetSnapGroupNamespace(id uint64) (*SnapGroupNamespace, error) {
+	var ns C.rbd_snap_group_namespace_t
+
+	if result := C.rbd_snap_get_group_namespace(image.handle, C.uint64_t(id), &ns, C.sizeof_rbd_snap_group_namespace_t); result < 0 {
+		return nil, fmt.Errorf("Unable to get group namespace of snapshot %d of image '%s'", id, image.name)
+	}
+	defer C.rbd_snap_group_namespace_cleanup(&ns, C.sizeof_rbd_snap_group_namespace_t)
+
+	return &SnapGroupNamespace{
+		GroupPool:     int64(ns.group_pool),
+		GroupName:     C.GoString(ns.group_name),
+		GroupSnapName: C.GoString(ns.group_snap_name),
+	}, nil
+}
+
+// Details of a snapshot used for snapshot-based mirroring
+type SnapMirrorNamespace struct {
+	State                  int
+	PeerUUIDs              []string
+	Complete               bool
+	PrimaryMirrorUUID      string
+	PrimarySnapID          uint64
+	LastCopiedObjectNumber uint64
+}
+
+func (image *Image) GetSnapMirrorNamespace(id uint64) (*SnapMirrorNamespace, error) {
+	var ns C.rbd_snap_mirror_namespace_t
+
+	if result := C.rbd_snap_get_mirror_namespace(image.handle, C.uint64_t(id), &ns, C.sizeof_rbd_snap_mirror_namespace_t); result < 0 {
+		return nil, fmt.Errorf("Unable to get mirror namespace of snapshot %d of image '%s'", id, image.name)
+	}
+	defer C.rbd_snap_mirror_namespace_cleanup(&ns, C.sizeof_rbd_snap_mirror_namespace_t)
+
+	// Peer UUIDs are packed as consecutive nul-terminated strings
+	peers := make([]string, 0, int(ns.mirror_peer_uuids_count))
+	peer := ns.mirror_peer_uuids
+	for i := 0; i < int(ns.mirror_peer_uuids_count); i++ {
+		uuid := C.GoString(peer)
+		peers = append(peers, uuid)
+		peer = (*C.char)(unsafe.Add(unsafe.Pointer(peer), len(uuid)+1))
+	}
+
+	return &SnapMirrorNamespace{
+		State:                  int(ns.state),
+		PeerUUIDs:              peers,
+		Complete:               bool(ns.complete),
+		PrimaryMirrorUUID:      C.GoString(ns.primary_mirror_uuid),
+		PrimarySnapID:          uint64(ns.primary_snap_id),
+		LastCopiedObjectNumber: uint64(ns.last_copied_object_number),
+	}, nil
+}
+
+// As CreateSnapshot(), with RBD_SNAP_CREATE_* flags controlling how
+// quiesce watchers on the image are notified
+func (image *Image) CreateSnapshot2(name string, flags uint32) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	// librbd requires a progress callback; ID 0 is never registered, so
+	// progress is ignored
+	if result := C._rbd_snap_create2(image.handle, c_name, C.uint32_t(flags), 0); result < 0 {
+		return fmt.Errorf("Unable to create snapshot '%s' on image '%s'", name, image.name)
+	}
+
+	return nil
+}
+
+// As RemoveSnapshot(), with RBD_SNAP_REMOVE_* flags to unprotect the
+// snapshot and/or flatten its clones first
+func (image *Image) RemoveSnapshot2(name string, flags uint32) error {
+	c_name := C.CString(name)
+	defer C.free(unsafe.Pointer(c_name))
+
+	if result := C._rbd_snap_remove2(image.handle, c_name, C.uint32_t(flags), 0); result < 0 {
+		return fmt.Errorf("Unable to remove snapshot '%s' from image '%s'", name, image.name)
+	}
+
+	return nil
+}
+
+// Returned by PurgeSnapshots() when some snapshots could not be removed,
+// keyed by snapshot name
+type SnapshotPurgeError struct {
+	Image  string
+	Errors map[string]error
+}
+
+func (err *SnapshotPurgeError) Error() string {
+	names := make([]string, 0, len(err.Errors))
+	for name := range err.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, err.Errors[name].Error())
+	}
+
+	return fmt.Sprintf("Unable to purge %d snapshots of image '%s': %s", len(names), err.Image, strings.Join(messages, "; "))
+}
+
+// Remove every user snapshot of the image. If unprotect is set, protected
+// snapshots are unprotected first; otherwise they are left in place and
+// reported. Removal carries on past failures, which are returned together as
+// a *SnapshotPurgeError
+func (image *Image) PurgeSnapshots(unprotect bool) error {
+	snaps, err := image.ListSnapshots()
+	if err != nil {
+		return err
+	}
+
+	var flags uint32
+	if unprotect {
+		flags = RBD_SNAP_REMOVE_UNPROTECT
+	}
+
+	errs := make(map[string]error)
+	for _, snap := range snaps {
+		// Trash, group and mirror snapshots cannot be removed by name, and
+		// are cleaned up by librbd along with whatever owns them
+		nsType, err := image.GetSnapNamespaceType(snap.ID)
+		if err != nil {
+			errs[snap.Name] = err
+			continue
+		}
+		if nsType != RBD_SNAP_NAMESPACE_TYPE_USER {
+			continue
+		}
+
+		if err := image.RemoveSnapshot2(snap.Name, flags); err != nil {
+			errs[snap.Name] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &SnapshotPurgeError{Image: image.name, Errors: errs}
+	}
+
+	return nil
+}
+
+// Returns the extents which changed between two snapshots. An empty fromSnap
+// diffs against the image's creation, and an empty toSnap against the current
+// image head. Changes inherited from a clone parent are included. The handle
+// is pointed at toSnap for the duration of the call and restored afterwards,
+// so it must not be used concurrently
+func (image *Image) DiffSnapshots(fromSnap string, toSnap string) ([]Extent, error) {
+	previous := image.snapshot
+
+	var err error
+	if toSnap == "" {
+		err = image.SetNoSnapshot()
+	} else {
+		err = image.SetSnapshot(toSnap)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	extents, err := image.diffExtents(fromSnap, toSnap)
+
+	// A failed restore would leave the handle reading the wrong snapshot,
+	// so it is reported even if the diff itself succeeded
+	var restoreErr error
+	if previous == "" {
+		restoreErr = image.SetNoSnapshot()
+	} else {
+		restoreErr = image.SetSnapshot(previous)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if restoreErr != nil {
+		return nil, restoreErr
+	}
+
+	return extents, nil
+}
+
+// Diff the snapshot the handle is set to against fromSnap
+func (image *Image) diffExtents(fromSnap string, toSnap string) ([]Extent, error) {
+	var c_fromSnap *C.char
+	if fromSnap != "" {
+		c_fromSnap = C.CString(fromSnap)
+		defer C.free(unsafe.Pointer(c_fromSnap))
+	}
+
+	var size C.uint64_t
+	if result := C.rbd_get_size(image.handle, &size); result < 0 {
+		return nil, fmt.Errorf("Unable to get size of image '%s'", image.name)
+	}
+
+	extents := make([]Extent, 0)
+	id := registerCallback(&extents)
+	defer unregisterCallback(id)
+
+	if result := C._rbd_diff_iterate2(image.handle, c_fromSnap, 0, size, C.uintptr_t(id)); result < 0 {
+		return nil, fmt.Errorf("Unable to diff snapshots '%s' and '%s' of image '%s'", fromSnap, toSnap, image.name)
+	}
+
+	return extents, nil
+}
@@ -0,0 +1,101 @@
+package gorbd
+
+import (
+	"fmt"
+	"strings"
+
+	rados "github.com/clbh/go-rados"
+)
+
+// An image reference in the rbd CLI's [pool/[namespace/]]image[@snapshot]
+// notation. Pool and Namespace are empty when not given
+type ImageSpec struct {
+	Pool      string
+	Namespace string
+	Image     string
+	Snapshot  string
+}
+
+func ParseImageSpec(spec string) (ImageSpec, error) {
+	var result ImageSpec
+
+	path := spec
+	if at := strings.Index(spec, "@"); at >= 0 {
+		path, result.Snapshot = spec[:at], spec[at+1:]
+		if result.Snapshot == "" {
+			return ImageSpec{}, fmt.Errorf("Invalid image spec '%s': empty snapshot name", spec)
+		}
+	}
+
+	parts := strings.Split(path, "/")
+	for _, part := range parts {
+		if part == "" {
+			return ImageSpec{}, fmt.Errorf("Invalid image spec '%s': empty component", spec)
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		result.Image = parts[0]
+	case 2:
+		result.Pool, result.Image = parts[0], parts[1]
+	case 3:
+		result.Pool, result.Namespace, result.Image = parts[0], parts[1], parts[2]
+	default:
+		return ImageSpec{}, fmt.Errorf("Invalid image spec '%s': too many components", spec)
+	}
+
+	return result, nil
+}
+
+func (spec ImageSpec) String() string {
+	var s strings.Builder
+
+	if spec.Pool != "" {
+		s.WriteString(spec.Pool + "/")
+
+		if spec.Namespace != "" {
+			s.WriteString(spec.Namespace + "/")
+		}
+	}
+
+	s.WriteString(spec.Image)
+
+	if spec.Snapshot != "" {
+		s.WriteString("@" + spec.Snapshot)
+	}
+
+	return s.String()
+}
+
+// Pool used for specs that do not name one, as with the rbd CLI
+const DEFAULT_SPEC_POOL = "rbd"
+
+// Open the image named by spec, resolving its pool and namespace through
+// conn. A spec without a pool refers to DEFAULT_SPEC_POOL
+func OpenSpec(conn *rados.Conn, spec ImageSpec, readonly bool) (*Image, error) {
+	poolName := spec.Pool
+	if poolName == "" {
+		poolName = DEFAULT_SPEC_POOL
+	}
+
+	pool, err := conn.OpenPool(poolName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open pool '%s'", poolName)
+	}
+	// librbd keeps its own copy of the pool handle, so ours can go as soon as
+	// the image is open
+	defer pool.Destroy()
+
+	if spec.Namespace == "" {
+		return openImage(pool, spec.Image, spec.Snapshot, readonly)
+	}
+
+	ns, err := OpenNamespace(pool, spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Destroy()
+
+	return openImage(ns, spec.Image, spec.Snapshot, readonly)
+}
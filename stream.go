@@ -0,0 +1,92 @@
+package gorbd
+
+import (
+	"errors"
+	"io"
+)
+
+// A seekable stream over the contents of an image, bounded by the image
+// size. ImageStream implements io.ReadWriteSeeker and io.Closer, so images can
+// be used with io.Copy and friends
+type ImageStream struct {
+	image  *Image
+	offset int64
+}
+
+// Create a stream positioned at the start of the image. Closing the stream
+// closes the image
+func NewImageStream(image *Image) *ImageStream {
+	return &ImageStream{image: image}
+}
+
+func (stream *ImageStream) Read(buf []byte) (int, error) {
+	size := int64(stream.image.Size())
+	if stream.offset >= size {
+		return 0, io.EOF
+	}
+
+	if remaining := size - stream.offset; int64(len(buf)) > remaining {
+		buf = buf[:remaining]
+	}
+
+	n, err := stream.image.Read(uint64(stream.offset), buf)
+	stream.offset += int64(n)
+
+	// A short read at the end of the image is not an error for io.Reader
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (stream *ImageStream) Write(data []byte) (int, error) {
+	size := int64(stream.image.Size())
+	if stream.offset >= size && len(data) > 0 {
+		return 0, io.ErrShortWrite
+	}
+
+	short := false
+	if remaining := size - stream.offset; int64(len(data)) > remaining {
+		data = data[:remaining]
+		short = true
+	}
+
+	n, err := stream.image.Write(uint64(stream.offset), data)
+	stream.offset += int64(n)
+
+	if err == nil && short {
+		err = io.ErrShortWrite
+	}
+
+	return n, err
+}
+
+func (stream *ImageStream) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = stream.offset + offset
+	case io.SeekEnd:
+		position = int64(stream.image.Size()) + offset
+	default:
+		return stream.offset, errors.New("Invalid seek whence")
+	}
+
+	if position < 0 {
+		return stream.offset, errors.New("Negative seek position")
+	}
+
+	stream.offset = position
+
+	return position, nil
+}
+
+func (stream *ImageStream) Close() error {
+	stream.image.Close()
+
+	return nil
+}
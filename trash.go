@@ -0,0 +1,142 @@
+package gorbd
+
+// #include <errno.h>
+// #include <stdlib.h>
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// What moved an image to the trash
+const (
+	RBD_TRASH_IMAGE_SOURCE_USER        = C.RBD_TRASH_IMAGE_SOURCE_USER
+	RBD_TRASH_IMAGE_SOURCE_MIRRORING   = C.RBD_TRASH_IMAGE_SOURCE_MIRRORING
+	RBD_TRASH_IMAGE_SOURCE_MIGRATION   = C.RBD_TRASH_IMAGE_SOURCE_MIGRATION
+	RBD_TRASH_IMAGE_SOURCE_REMOVING    = C.RBD_TRASH_IMAGE_SOURCE_REMOVING
+	RBD_TRASH_IMAGE_SOURCE_USER_PARENT = C.RBD_TRASH_IMAGE_SOURCE_USER_PARENT
+)
+
+type TrashEntry struct {
+	ID   string
+	Name string
+
+	// One of the RBD_TRASH_IMAGE_SOURCE_* constants
+	Source int
+
+	DeletionTime time.Time
+
+	// The entry cannot be removed (without forcing) before this time
+	DefermentEndTime time.Time
+}
+
+func newTrashEntry(info *C.rbd_trash_image_info_t) TrashEntry {
+	return TrashEntry{
+		ID:               C.GoString(info.id),
+		Name:             C.GoString(info.name),
+		Source:           int(info.source),
+		DeletionTime:     time.Unix(int64(info.deletion_time), 0),
+		DefermentEndTime: time.Unix(int64(info.deferment_end_time), 0),
+	}
+}
+
+// Move an image to the trash. It cannot be removed from the trash until delay
+// has passed, unless forced
+func TrashMove(pool IOContext, imageName string, delay time.Duration) error {
+	if delay < 0 {
+		return errors.New("Trash deferment delay must not be negative")
+	}
+
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	if result := C.rbd_trash_move(C.rados_ioctx_t(pool.Handle()), c_imageName, C.uint64_t(delay/time.Second)); result < 0 {
+		return fmt.Errorf("Unable to move image '%s' to trash", imageName)
+	}
+
+	return nil
+}
+
+func TrashList(pool IOContext) ([]TrashEntry, error) {
+	max := C.size_t(16)
+
+	for {
+		infos := make([]C.rbd_trash_image_info_t, max)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := C.rbd_trash_list(C.rados_ioctx_t(pool.Handle()), &infos[0], &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list trash")
+		}
+
+		entries := make([]TrashEntry, 0, int(max))
+		for i := range infos[:max] {
+			entries = append(entries, newTrashEntry(&infos[i]))
+		}
+
+		C.rbd_trash_list_cleanup(&infos[0], max)
+
+		return entries, nil
+	}
+}
+
+func TrashGet(pool IOContext, id string) (*TrashEntry, error) {
+	c_id := C.CString(id)
+	defer C.free(unsafe.Pointer(c_id))
+	var info C.rbd_trash_image_info_t
+
+	if result := C.rbd_trash_get(C.rados_ioctx_t(pool.Handle()), c_id, &info); result < 0 {
+		return nil, fmt.Errorf("Unable to get trash entry '%s'", id)
+	}
+	defer C.rbd_trash_get_cleanup(&info)
+
+	entry := newTrashEntry(&info)
+
+	return &entry, nil
+}
+
+// Restore an image from the trash under the given name
+func TrashRestore(pool IOContext, id string, imageName string) error {
+	c_id := C.CString(id)
+	defer C.free(unsafe.Pointer(c_id))
+	c_imageName := C.CString(imageName)
+	defer C.free(unsafe.Pointer(c_imageName))
+
+	if result := C.rbd_trash_restore(C.rados_ioctx_t(pool.Handle()), c_id, c_imageName); result < 0 {
+		return fmt.Errorf("Unable to restore trash entry '%s' as image '%s'", id, imageName)
+	}
+
+	return nil
+}
+
+// Permanently remove an image from the trash. force allows removal before
+// the entry's deferment period has ended
+func TrashRemove(pool IOContext, id string, force bool) error {
+	c_id := C.CString(id)
+	defer C.free(unsafe.Pointer(c_id))
+
+	if result := C.rbd_trash_remove(C.rados_ioctx_t(pool.Handle()), c_id, C.bool(force)); result < 0 {
+		return fmt.Errorf("Unable to remove trash entry '%s'", id)
+	}
+
+	return nil
+}
+
+// Remove every trash entry whose deferment period ended before expiredBefore.
+// If threshold is between 0 and 1, entries are also removed (oldest first)
+// until the pool's usage falls below that fraction of its quota; pass -1 to
+// disable this
+func TrashPurge(pool IOContext, expiredBefore time.Time, threshold float32) error {
+	if result := C.rbd_trash_purge(C.rados_ioctx_t(pool.Handle()), C.time_t(expiredBefore.Unix()), C.float(threshold)); result < 0 {
+		return fmt.Errorf("Unable to purge trash")
+	}
+
+	return nil
+}
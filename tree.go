@@ -0,0 +1,171 @@
+package gorbd
+
+// #include <rbd/librbd.h>
+import "C"
+
+import (
+	"errors"
+	"sort"
+)
+
+// The images of a pool and the clone relationships between them
+type CloneTree struct {
+	Images map[string]*ImageNode
+
+	// Images which are not clones of another image in the pool, sorted by name
+	Roots []*ImageNode
+}
+
+type ImageNode struct {
+	Name      string
+	Snapshots []*SnapshotNode
+
+	// The snapshot this image was cloned from, if it is in the same pool and
+	// namespace. Otherwise ExternalParent describes the parent of a clone
+	Parent         *SnapshotNode
+	ExternalParent *ParentSpec
+}
+
+type SnapshotNode struct {
+	Info          SnapInfo
+	NamespaceType int
+	Image         *ImageNode
+
+	// Clones of this snapshot within the pool, sorted by name
+	Children []*ImageNode
+}
+
+func (node *ImageNode) snapshot(id uint64) *SnapshotNode {
+	for _, snap := range node.Snapshots {
+		if snap.Info.ID == id {
+			return snap
+		}
+	}
+
+	return nil
+}
+
+// Build the clone tree of a pool, covering every image in the pool's current
+// namespace. Clone v2 parents whose snapshots have been moved to the trash
+// namespace appear as snapshots with RBD_SNAP_NAMESPACE_TYPE_TRASH
+func BuildCloneTree(pool IOContext) (*CloneTree, error) {
+	names, err := ListImages(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	ioctx := C.rados_ioctx_t(pool.Handle())
+	poolID := int64(C.rados_ioctx_get_id(ioctx))
+
+	namespace, err := poolNamespace(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &CloneTree{Images: make(map[string]*ImageNode)}
+	nodes := make([]*ImageNode, 0, len(names))
+
+	for _, name := range names {
+		node, err := newImageNode(pool, name)
+		if err != nil {
+			return nil, err
+		}
+
+		tree.Images[name] = node
+		nodes = append(nodes, node)
+	}
+
+	for _, node := range nodes {
+		parent := node.ExternalParent
+		if parent == nil {
+			continue
+		}
+
+		parentNode := tree.Images[parent.Image.ImageName]
+		if parent.Image.PoolID != poolID || parent.Image.Namespace != namespace || parent.Image.Trash || parentNode == nil {
+			continue
+		}
+
+		snap := parentNode.snapshot(parent.Snap.ID)
+		if snap == nil {
+			// The snapshot was created after the parent's snapshots were
+			// listed
+			snap = &SnapshotNode{
+				Info:          SnapInfo{ID: parent.Snap.ID, Name: parent.Snap.Name},
+				NamespaceType: parent.Snap.NamespaceType,
+				Image:         parentNode,
+			}
+			parentNode.Snapshots = append(parentNode.Snapshots, snap)
+		}
+
+		snap.Children = append(snap.Children, node)
+		node.Parent = snap
+		node.ExternalParent = nil
+	}
+
+	for _, node := range nodes {
+		if node.Parent == nil {
+			tree.Roots = append(tree.Roots, node)
+		}
+
+		for _, snap := range node.Snapshots {
+			sort.Slice(snap.Children, func(i, j int) bool {
+				return snap.Children[i].Name < snap.Children[j].Name
+			})
+		}
+	}
+
+	sort.Slice(tree.Roots, func(i, j int) bool {
+		return tree.Roots[i].Name < tree.Roots[j].Name
+	})
+
+	return tree, nil
+}
+
+// Read an image's snapshots and parent. The parent is recorded as external
+// until BuildCloneTree() links it up
+func newImageNode(pool IOContext, name string) (*ImageNode, error) {
+	image, err := OpenImageRO(pool, name)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	snaps, err := image.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := image.GetParent()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &ImageNode{Name: name, ExternalParent: parent}
+	for _, snap := range snaps {
+		nsType, err := image.GetSnapNamespaceType(snap.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		node.Snapshots = append(node.Snapshots, &SnapshotNode{
+			Info:          snap,
+			NamespaceType: nsType,
+			Image:         node,
+		})
+	}
+
+	return node, nil
+}
+
+// Returns the rados namespace the pool handle is bound to
+func poolNamespace(pool IOContext) (string, error) {
+	var buf [256]C.char
+
+	result := C.rados_ioctx_get_namespace(C.rados_ioctx_t(pool.Handle()), &buf[0], C.uint(len(buf)))
+	if result < 0 {
+		return "", errors.New("Failed to get pool namespace")
+	}
+
+	return C.GoStringN(&buf[0], result), nil
+}
@@ -0,0 +1,210 @@
+package gorbd
+
+// #include <errno.h>
+// #include <rbd/librbd.h>
+//
+// extern void goUpdateCallback(void*);
+// extern void goQuiesceCallback(void*);
+// extern void goUnquiesceCallback(void*);
+//
+// static inline int _rbd_update_watch(rbd_image_t image, uint64_t *handle, uintptr_t arg) {
+// 	return rbd_update_watch(image, handle, goUpdateCallback, (void*)arg);
+// }
+//
+// static inline int _rbd_quiesce_watch(rbd_image_t image, uintptr_t arg, uint64_t *handle) {
+// 	return rbd_quiesce_watch(image, goQuiesceCallback, goUnquiesceCallback, (void*)arg, handle);
+// }
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// A client watching the image header, which every client with the image
+// open (or mapped) does
+type Watcher struct {
+	Addr   string
+	ID     int64
+	Cookie uint64
+}
+
+func (image *Image) ListWatchers() ([]Watcher, error) {
+	max := C.size_t(8)
+
+	for {
+		watchers := make([]C.rbd_image_watcher_t, max)
+
+		// On -ERANGE, max is updated to the number of entries required
+		result := C.rbd_watchers_list(image.handle, &watchers[0], &max)
+		if result == -C.ERANGE {
+			continue
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("Unable to list watchers of image '%s'", image.name)
+		}
+
+		list := make([]Watcher, 0, int(max))
+		for _, watcher := range watchers[:max] {
+			list = append(list, Watcher{
+				Addr:   C.GoString(watcher.addr),
+				ID:     int64(watcher.id),
+				Cookie: uint64(watcher.cookie),
+			})
+		}
+
+		C.rbd_watchers_list_cleanup(&watchers[0], max)
+
+		return list, nil
+	}
+}
+
+type updateWatch struct {
+	notify chan struct{}
+}
+
+//export goUpdateCallback
+func goUpdateCallback(arg unsafe.Pointer) {
+	watch, _ := lookupCallback(uintptr(arg)).(*updateWatch)
+	if watch == nil {
+		return
+	}
+
+	// Notifications are coalesced: one pending signal is enough to tell the
+	// receiver to refresh
+	select {
+	case watch.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Watch the image header for changes such as resizes, snapshots or feature
+// updates. A value is sent on the returned channel after each change, with
+// bursts of changes coalesced. The returned function stops the watch and
+// closes the channel
+func (image *Image) Watch() (<-chan struct{}, func() error, error) {
+	watch := &updateWatch{notify: make(chan struct{}, 1)}
+	id := registerCallback(watch)
+
+	var handle C.uint64_t
+	if result := C._rbd_update_watch(image.handle, &handle, C.uintptr_t(id)); result < 0 {
+		unregisterCallback(id)
+		return nil, nil, fmt.Errorf("Unable to watch image '%s'", image.name)
+	}
+
+	var once sync.Once
+	var err error
+
+	unwatch := func() error {
+		once.Do(func() {
+			// No callbacks are delivered once rbd_update_unwatch() returns
+			if result := C.rbd_update_unwatch(image.handle, handle); result < 0 {
+				err = fmt.Errorf("Unable to unwatch image '%s'", image.name)
+			}
+
+			unregisterCallback(id)
+			close(watch.notify)
+		})
+
+		return err
+	}
+
+	return watch.notify, unwatch, nil
+}
+
+type quiesceWatch struct {
+	image     *Image
+	handle    C.uint64_t
+	quiesce   func() error
+	unquiesce func()
+}
+
+//export goQuiesceCallback
+func goQuiesceCallback(arg unsafe.Pointer) {
+	watch, _ := lookupCallback(uintptr(arg)).(*quiesceWatch)
+	if watch == nil {
+		return
+	}
+
+	// The handler may take a while (freezing a filesystem, say), so it runs
+	// off the librbd thread and reports back through rbd_quiesce_complete()
+	go func() {
+		var result C.int
+		if err := watch.quiesce(); err != nil {
+			result = -C.EIO
+		}
+
+		C.rbd_quiesce_complete(watch.image.handle, watch.handle, result)
+	}()
+}
+
+//export goUnquiesceCallback
+func goUnquiesceCallback(arg unsafe.Pointer) {
+	watch, _ := lookupCallback(uintptr(arg)).(*quiesceWatch)
+	if watch == nil {
+		return
+	}
+
+	watch.unquiesce()
+}
+
+// Take part in quiescing the image when a snapshot is created by any client.
+// quiesce is called before the snapshot is taken; if it returns an error,
+// snapshot creation fails unless the creator passed
+// RBD_SNAP_CREATE_IGNORE_QUIESCE_ERROR. unquiesce is called once the snapshot
+// has been taken. The returned function removes the handlers
+func (image *Image) QuiesceWatch(quiesce func() error, unquiesce func()) (func() error, error) {
+	watch := &quiesceWatch{
+		image:     image,
+		quiesce:   quiesce,
+		unquiesce: unquiesce,
+	}
+	id := registerCallback(watch)
+
+	if result := C._rbd_quiesce_watch(image.handle, C.uintptr_t(id), &watch.handle); result < 0 {
+		unregisterCallback(id)
+		return nil, fmt.Errorf("Unable to watch image '%s' for quiesce requests", image.name)
+	}
+
+	var once sync.Once
+	var err error
+
+	unwatch := func() error {
+		once.Do(func() {
+			if result := C.rbd_quiesce_unwatch(image.handle, watch.handle); result < 0 {
+				err = fmt.Errorf("Unable to stop watching image '%s' for quiesce requests", image.name)
+			}
+
+			unregisterCallback(id)
+		})
+
+		return err
+	}
+
+	return unwatch, nil
+}
+
+// Reports whether the image is in use elsewhere: watched by another client,
+// holding the exclusive lock or carrying an advisory lock. The image should
+// be opened read-only for this check, as a writable handle is itself a
+// watcher
+func (image *Image) InUse() (bool, []Watcher, error) {
+	watchers, err := image.ListWatchers()
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Holders of the managed exclusive lock are listed alongside advisory
+	// lockers (with an "auto <id>" cookie). LockGetOwners() is not used, as
+	// it fails on images without the exclusive-lock feature and, with older
+	// librbd, on read-only handles
+	lockers, err := image.ListLockers()
+	if err != nil {
+		return false, nil, err
+	}
+
+	inUse := len(watchers) > 0 || len(lockers.Lockers) > 0
+
+	return inUse, watchers, nil
+}